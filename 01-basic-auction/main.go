@@ -10,27 +10,37 @@ import (
 )
 
 type AuctionInfo struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64 `json:"auction_end_time"`
-	Auctioneer     string `json:"auctioneer"`
-	Claimed        bool   `json:"claimed"`
+	HighestBid     core.Bid          `json:"highest_bid"`
+	AuctionEndTime uint64            `json:"auction_end_time"`
+	Auctioneer     string            `json:"auctioneer"`
+	Claimed        bool              `json:"claimed"`
+	ProtocolFee    *core.ProtocolFee `json:"protocol_fee"`
+	Factory        string            `json:"factory"`
 }
 
 type InitInput struct {
-	EndTime    uint64 `json:"end_time"`
-	Auctioneer string `json:"auctioneer"`
+	EndTime     uint64            `json:"end_time"`
+	Auctioneer  string            `json:"auctioneer"`
+	ProtocolFee *core.ProtocolFee `json:"protocol_fee"`
 }
 
 // @contract:state
 type AuctionContract struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64 `json:"auction_end_time"`
-	Auctioneer     string `json:"auctioneer"`
-	Claimed        bool   `json:"claimed"`
+	HighestBid     core.Bid          `json:"highest_bid"`
+	AuctionEndTime uint64            `json:"auction_end_time"`
+	Auctioneer     string            `json:"auctioneer"`
+	Claimed        bool              `json:"claimed"`
+	ProtocolFee    *core.ProtocolFee `json:"protocol_fee"`
+	Factory        string            `json:"factory"`
 }
 
 // @contract:init
 func (c *AuctionContract) Init(input InitInput) {
+	if fee := input.ProtocolFee; fee != nil && (fee.Account == "" || fee.Bps > core.FeeBpsDenominator) {
+		env.PanicStr("invalid protocol fee")
+		return
+	}
+
 	currentAccount, _ := env.GetCurrentAccountId()
 	c.HighestBid = core.Bid{
 		Bidder: currentAccount,
@@ -39,6 +49,8 @@ func (c *AuctionContract) Init(input InitInput) {
 	c.AuctionEndTime = input.EndTime
 	c.Auctioneer = input.Auctioneer
 	c.Claimed = false
+	c.ProtocolFee = input.ProtocolFee
+	c.Factory, _ = env.GetPredecessorAccountID()
 	env.LogString("Auction initialized")
 }
 
@@ -99,11 +111,48 @@ func (c *AuctionContract) Claim() error {
 		return errors.New("invalid winning bid amount in state")
 	}
 
-	promise.CreateBatch(c.Auctioneer).Transfer(winningBid)
+	return c.payAuctioneer(winningBid)
+}
 
+// payAuctioneer pays amount to the auctioneer, less the protocol fee, which
+// goes to the fee account through collect_fee.
+func (c *AuctionContract) payAuctioneer(amount types.Uint128) error {
+	rest, err := core.CollectFee(c.ProtocolFee, amount)
+	if err != nil {
+		return errors.New("failed to compute the protocol fee")
+	}
+
+	promise.CreateBatch(c.Auctioneer).Transfer(rest)
 	return nil
 }
 
+// SelfDestruct deletes the auction account once it has been claimed and the
+// grace period after its end has passed, sending the remaining balance to
+// the beneficiary. Only the factory that deployed the auction can call it,
+// and it gets the balance sent back so it can account for it.
+//
+// @contract:mutating
+func (c *AuctionContract) SelfDestruct(input core.SelfDestructArgs) (string, error) {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return "", errors.New("failed to get caller account")
+	}
+	if c.Factory == "" || caller != c.Factory {
+		return "", errors.New("only the factory can delete the auction")
+	}
+	if !c.Claimed {
+		return "", errors.New("the auction has not been settled")
+	}
+	if env.GetBlockTimeMs() < c.AuctionEndTime+core.SelfDestructGraceMs {
+		return "", errors.New("the grace period has not passed")
+	}
+	if input.Beneficiary == "" {
+		return "", errors.New("invalid beneficiary")
+	}
+
+	return core.DeleteSelf(input.Beneficiary)
+}
+
 // @contract:view
 func (c *AuctionContract) GetHighestBid() core.Bid {
 	return c.HighestBid
@@ -131,5 +180,7 @@ func (c *AuctionContract) GetAuctionInfo() AuctionInfo {
 		AuctionEndTime: c.AuctionEndTime,
 		Auctioneer:     c.Auctioneer,
 		Claimed:        c.Claimed,
+		ProtocolFee:    c.ProtocolFee,
+		Factory:        c.Factory,
 	}
 }
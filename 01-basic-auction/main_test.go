@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/emirsuyunasanov/near-auction-go/core"
 	"github.com/vlmoon99/near-sdk-go/env"
 	"github.com/vlmoon99/near-sdk-go/system"
 	"github.com/vlmoon99/near-sdk-go/types"
@@ -207,6 +208,40 @@ func TestAuction_Claim_Success(t *testing.T) {
 	}
 }
 
+func TestAuction_Claim_ProtocolFee(t *testing.T) {
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:     auctionEndTimeMs,
+		Auctioneer:  "auctioneer.testnet",
+		ProtocolFee: &core.ProtocolFee{Account: "factory.testnet", Bps: 250},
+	})
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.Bid(); err != nil {
+		t.Fatalf("bid failed: %v", err)
+	}
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if fee := c.GetAuctionInfo().ProtocolFee; fee == nil || fee.Bps != 250 {
+		t.Errorf("unexpected protocol fee: %+v", fee)
+	}
+}
+
+func TestAuction_Init_InvalidProtocolFee(t *testing.T) {
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:     auctionEndTimeMs,
+		Auctioneer:  "auctioneer.testnet",
+		ProtocolFee: &core.ProtocolFee{Account: "factory.testnet", Bps: core.FeeBpsDenominator + 1},
+	})
+	if c.ProtocolFee != nil {
+		t.Error("expected a fee above 100% to be rejected")
+	}
+}
+
 func TestAuction_Claim_AlreadyClaimed(t *testing.T) {
 	c := setupTest(t)
 
@@ -267,3 +302,44 @@ func TestAuction_FullLifecycle(t *testing.T) {
 		t.Errorf("winner should be bob, got %s", info.HighestBid.Bidder)
 	}
 }
+
+func TestAuction_SelfDestruct(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "factory.testnet"
+	c.Init(InitInput{EndTime: auctionEndTimeMs, Auctioneer: "auctioneer.testnet"})
+
+	beneficiary := core.SelfDestructArgs{Beneficiary: "creator.testnet"}
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "the auction has not been settled" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.Bid(); err != nil {
+		t.Fatalf("bid failed: %v", err)
+	}
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "the grace period has not passed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setBlockTime(t, (auctionEndTimeMs+core.SelfDestructGraceMs)*1_000_000)
+	m.PredecessorAccountIdSys = "alice.testnet"
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "only the factory can delete the auction" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.PredecessorAccountIdSys = "factory.testnet"
+	m.AccountBalanceSys = types.Uint128{Hi: 0, Lo: 2_500}
+	sent, err := c.SelfDestruct(beneficiary)
+	if err != nil {
+		t.Fatalf("self destruct failed: %v", err)
+	}
+	if sent != "2500" {
+		t.Errorf("self destruct should report the balance it sends, got %s", sent)
+	}
+}
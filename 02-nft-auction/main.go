@@ -9,34 +9,156 @@ import (
 	"github.com/vlmoon99/near-sdk-go/types"
 )
 
+// maxBundleSize caps how many tokens a bundle lot can hold, so that
+// delivering all of them fits in the gas of a single Claim.
+const maxBundleSize = 5
+
+// Settlement states. An auction that has not been claimed yet has no
+// settlement state.
+const (
+	SettlementPending = "pending"
+	SettlementDone    = "settled"
+	SettlementFailed  = "settlement_failed"
+	SettlementPartial = "partially_delivered"
+)
+
+// Verification states of the listed token. Bids are only accepted once the
+// token has been verified.
+const (
+	VerificationDeferred = "deferred"
+	VerificationPending  = "pending"
+	VerificationDone     = "verified"
+	VerificationFailed   = "verification_failed"
+)
+
+// Delivery states of the tokens in a bundle lot.
+const (
+	DeliveryPending = "pending"
+	DeliveryDone    = "delivered"
+	DeliveryFailed  = "failed"
+)
+
 type AuctionInfo struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64   `json:"auction_end_time"`
-	Auctioneer     string   `json:"auctioneer"`
-	Claimed        bool     `json:"claimed"`
-	NftContract    string   `json:"nft_contract"`
-	TokenId        string   `json:"token_id"`
+	HighestBid     core.Bid            `json:"highest_bid"`
+	AuctionEndTime uint64              `json:"auction_end_time"`
+	Auctioneer     string              `json:"auctioneer"`
+	ReturnAddress  string              `json:"return_address"`
+	Claimed        bool                `json:"claimed"`
+	Settlement     string              `json:"settlement"`
+	NftContract    string              `json:"nft_contract"`
+	TokenId        string              `json:"token_id"`
+	ApprovalId     *uint64             `json:"approval_id"`
+	Bundle         []BundleItem        `json:"bundle"`
+	Verification   string              `json:"verification"`
+	Metadata       *core.TokenMetadata `json:"metadata"`
+	ProtocolFee    *core.ProtocolFee   `json:"protocol_fee"`
+	Factory        string              `json:"factory"`
 }
 
 type InitInput struct {
-	EndTime     uint64 `json:"end_time"`
-	Auctioneer  string `json:"auctioneer"`
+	EndTime           uint64            `json:"end_time"`
+	Auctioneer        string            `json:"auctioneer"`
+	NftContract       string            `json:"nft_contract"`
+	TokenId           string            `json:"token_id"`
+	ReturnAddress     string            `json:"return_address"`
+	Bundle            []BundleToken     `json:"bundle"`
+	ProtocolFee       *core.ProtocolFee `json:"protocol_fee"`
+	DeferVerification bool              `json:"defer_verification"`
+}
+
+// BundleToken is one of the NFTs sold together in a bundle lot.
+type BundleToken struct {
 	NftContract string `json:"nft_contract"`
 	TokenId     string `json:"token_id"`
 }
 
+// BundleItem tracks a bundle token through escrow and delivery.
+type BundleItem struct {
+	NftContract string `json:"nft_contract"`
+	TokenId     string `json:"token_id"`
+	Escrowed    bool   `json:"escrowed"`
+	Delivery    string `json:"delivery"`
+}
+
+type NftOnTransferInput struct {
+	SenderId        string `json:"sender_id"`
+	PreviousOwnerId string `json:"previous_owner_id"`
+	TokenId         string `json:"token_id"`
+	Msg             string `json:"msg"`
+}
+
+type NftOnApproveInput struct {
+	TokenId    string `json:"token_id"`
+	OwnerId    string `json:"owner_id"`
+	ApprovalId uint64 `json:"approval_id"`
+	Msg        string `json:"msg"`
+}
+
+type ClaimCallbackInput struct {
+	Winner        string `json:"winner"`
+	Amount        string `json:"amount"`
+	PlainTransfer bool   `json:"plain_transfer"`
+}
+
+type NoSaleCallbackInput struct {
+	ReturnTo string `json:"return_to"`
+}
+
+type BundleCallbackInput struct {
+	Index    int    `json:"index"`
+	Receiver string `json:"receiver"`
+}
+
 // @contract:state
 type NftAuctionContract struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64   `json:"auction_end_time"`
-	Auctioneer     string   `json:"auctioneer"`
-	Claimed        bool     `json:"claimed"`
-	NftContract    string   `json:"nft_contract"`
-	TokenId        string   `json:"token_id"`
+	HighestBid     core.Bid            `json:"highest_bid"`
+	AuctionEndTime uint64              `json:"auction_end_time"`
+	Auctioneer     string              `json:"auctioneer"`
+	ReturnAddress  string              `json:"return_address"`
+	Claimed        bool                `json:"claimed"`
+	Settlement     string              `json:"settlement"`
+	NftContract    string              `json:"nft_contract"`
+	TokenId        string              `json:"token_id"`
+	ApprovalId     *uint64             `json:"approval_id,omitempty"`
+	Bundle         []BundleItem        `json:"bundle,omitempty"`
+	Verification   string              `json:"verification"`
+	Metadata       *core.TokenMetadata `json:"metadata,omitempty"`
+	ProtocolFee    *core.ProtocolFee   `json:"protocol_fee,omitempty"`
+	Factory        string              `json:"factory"`
 }
 
+// Init sets up the lot. It is either a single token or, when Bundle is
+// given, a set of tokens that are sold as one unit once all of them have been
+// escrowed through nft_transfer_call. A single token is looked up with
+// nft_token and only opens for bids once it is known to belong to the
+// auctioneer. With DeferVerification the lookup waits for VerifyToken, for a
+// deployer that only sends the token to the auction after the init.
+//
 // @contract:init
 func (c *NftAuctionContract) Init(input InitInput) {
+	if fee := input.ProtocolFee; fee != nil && (fee.Account == "" || fee.Bps > core.FeeBpsDenominator) {
+		env.PanicStr("invalid protocol fee")
+		return
+	}
+	if len(input.Bundle) > maxBundleSize {
+		env.PanicStr("bundle can hold at most " + types.IntToString(maxBundleSize) + " tokens")
+		return
+	}
+
+	bundle := make([]BundleItem, 0, len(input.Bundle))
+	for _, token := range input.Bundle {
+		for _, item := range bundle {
+			if item.NftContract == token.NftContract && item.TokenId == token.TokenId {
+				env.PanicStr("bundle lists token " + token.TokenId + " twice")
+				return
+			}
+		}
+		bundle = append(bundle, BundleItem{
+			NftContract: token.NftContract,
+			TokenId:     token.TokenId,
+		})
+	}
+
 	currentAccount, _ := env.GetCurrentAccountId()
 	c.HighestBid = core.Bid{
 		Bidder: currentAccount,
@@ -44,10 +166,83 @@ func (c *NftAuctionContract) Init(input InitInput) {
 	}
 	c.AuctionEndTime = input.EndTime
 	c.Auctioneer = input.Auctioneer
+	c.ReturnAddress = input.ReturnAddress
+	if c.ReturnAddress == "" {
+		c.ReturnAddress = input.Auctioneer
+	}
 	c.Claimed = false
+	c.Settlement = ""
 	c.NftContract = input.NftContract
 	c.TokenId = input.TokenId
+	c.Bundle = nil
+	c.Metadata = nil
+	c.ProtocolFee = input.ProtocolFee
+	c.Factory, _ = env.GetPredecessorAccountID()
 	env.LogString("NFT Auction initialized")
+
+	if len(bundle) > 0 {
+		// Bundle tokens prove ownership by being escrowed.
+		c.Bundle = bundle
+		c.Verification = VerificationDone
+		return
+	}
+	if input.DeferVerification {
+		c.Verification = VerificationDeferred
+		return
+	}
+
+	c.verifyToken()
+}
+
+// VerifyToken looks the listed token up, once it has been sent to an auction
+// that deferred its verification, or again after a failed verification, for
+// instance once the auctioneer has received the token.
+//
+// @contract:mutating
+func (c *NftAuctionContract) VerifyToken() error {
+	if c.Verification != VerificationDeferred && c.Verification != VerificationFailed {
+		return errors.New("the token does not need verification")
+	}
+
+	c.verifyToken()
+	return nil
+}
+
+func (c *NftAuctionContract) verifyToken() {
+	c.Verification = VerificationPending
+	core.VerifyToken(c.NftContract, c.TokenId)
+}
+
+// VerifyTokenCallback checks the nft_token result: the token has to exist
+// and be owned by the auctioneer, or already by the auction itself. Its
+// title, media and reference are kept for GetAuctionInfo.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *NftAuctionContract) VerifyTokenCallback(input core.VerifyTokenCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if input.NftContract != c.NftContract || input.TokenId != c.TokenId {
+		env.LogString("Verification result is for another token")
+		return false
+	}
+
+	token, err := core.VerifiedToken(result, c.TokenId, c.Auctioneer, current)
+	if err != nil {
+		c.Verification = VerificationFailed
+		env.LogString("Token verification failed: " + err.Error())
+		return false
+	}
+
+	c.Verification = VerificationDone
+	c.Metadata = token.Metadata
+	env.LogString("Token " + c.TokenId + " verified, owned by " + token.OwnerId)
+	return true
 }
 
 // @contract:mutating
@@ -57,6 +252,14 @@ func (c *NftAuctionContract) Bid() error {
 		return errors.New("auction has ended")
 	}
 
+	if c.Verification != VerificationDone {
+		return errors.New("the token has not been verified")
+	}
+
+	if !c.bundleComplete() {
+		return errors.New("the bundle is not complete yet")
+	}
+
 	deposit, err := env.GetAttachedDeposit()
 	if err != nil {
 		return errors.New("failed to get attached deposit")
@@ -89,6 +292,90 @@ func (c *NftAuctionContract) Bid() error {
 	return nil
 }
 
+// NftOnTransfer escrows a bundle token sent with nft_transfer_call. It
+// returns true, which makes the NFT contract send the token back, for
+// anything that is not an outstanding token of the bundle coming from the
+// auctioneer.
+//
+// @contract:mutating
+func (c *NftAuctionContract) NftOnTransfer(input NftOnTransferInput) bool {
+	nft, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return true
+	}
+
+	if c.Claimed || env.GetBlockTimeMs() >= c.AuctionEndTime {
+		env.LogString("Auction has ended, returning the token")
+		return true
+	}
+
+	if input.PreviousOwnerId != c.Auctioneer {
+		env.LogString("Only the auctioneer can escrow bundle tokens, returning the token")
+		return true
+	}
+
+	for i := range c.Bundle {
+		item := &c.Bundle[i]
+		if item.NftContract != nft || item.TokenId != input.TokenId {
+			continue
+		}
+		if item.Escrowed {
+			return true
+		}
+
+		item.Escrowed = true
+		env.LogString("Escrowed token " + input.TokenId + " from " + nft)
+		if c.bundleComplete() {
+			env.LogString("Bundle complete, auction is open")
+		}
+		return false
+	}
+
+	env.LogString("Token " + input.TokenId + " is not part of the bundle, returning it")
+	return true
+}
+
+// NftOnApprove lists the token without escrow: the auctioneer keeps the NFT
+// and approves this contract on the NFT contract, which then calls back here.
+//
+// @contract:mutating
+func (c *NftAuctionContract) NftOnApprove(input NftOnApproveInput) error {
+	if c.isBundle() {
+		return errors.New("bundle tokens must be escrowed")
+	}
+
+	nft, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+	if nft != c.NftContract {
+		return errors.New("the nft contract is not supported")
+	}
+
+	if input.TokenId != c.TokenId {
+		return errors.New("the token is not listed in this auction")
+	}
+
+	if input.OwnerId != c.Auctioneer {
+		return errors.New("only the auctioneer can approve the token")
+	}
+
+	if c.Claimed {
+		return errors.New("auction has already been claimed")
+	}
+
+	approvalId := input.ApprovalId
+	c.ApprovalId = &approvalId
+	env.LogString("Token approved with approval_id " + types.Uint64ToString(approvalId))
+
+	return nil
+}
+
+// Claim settles the auction. The NFT contract is first probed with nft_payout
+// and the token then goes out through NEP-199 nft_transfer_payout so
+// royalties can be honored; the winning bid is only paid out in the callback,
+// once the NFT contract has moved the token.
+//
 // @contract:mutating
 func (c *NftAuctionContract) Claim() error {
 	blockTime := env.GetBlockTimeMs()
@@ -100,30 +387,502 @@ func (c *NftAuctionContract) Claim() error {
 		return errors.New("auction has already been claimed")
 	}
 
-	c.Claimed = true
+	if c.isBundle() {
+		return c.settleBundle()
+	}
+
+	if !c.hasBids() {
+		return c.settleNoSale()
+	}
+
+	if c.Settlement == SettlementFailed {
+		return errors.New("settlement failed, the winner can retry it or the auctioneer can take the token back")
+	}
+
+	return c.settle()
+}
 
+// RetrySettlement lets the winner of an auction whose settlement failed, and
+// who was refunded for it, try again once the token can be delivered (for
+// example after the auctioneer re-approved it). The winning bid has to be
+// attached again.
+//
+// @contract:mutating
+func (c *NftAuctionContract) RetrySettlement() error {
+	if c.Settlement != SettlementFailed {
+		return errors.New("settlement has not failed")
+	}
+
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+	if caller != c.HighestBid.Bidder {
+		return errors.New("only the winner can retry the settlement")
+	}
+
+	deposit, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
+
+	winningBid, err := types.U128FromString(c.HighestBid.Amount)
+	if err != nil {
+		return errors.New("invalid winning bid amount in state")
+	}
+
+	if deposit.Cmp(winningBid) != 0 {
+		return errors.New("attach exactly the winning bid to retry")
+	}
+
+	return c.settle()
+}
+
+// ReturnToken lets the auctioneer give up on a failed settlement, whose winner
+// has already been refunded, and send the token back to the return address
+// the way an auction without bids does.
+//
+// @contract:mutating
+func (c *NftAuctionContract) ReturnToken() error {
+	if c.Settlement != SettlementFailed || c.isBundle() {
+		return errors.New("settlement has not failed")
+	}
+
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+	if caller != c.Auctioneer {
+		return errors.New("only the auctioneer can take the token back")
+	}
+
+	env.LogString("Settlement abandoned, returning the token to " + c.ReturnAddress)
+	return c.settleNoSale()
+}
+
+func (c *NftAuctionContract) settle() error {
 	winningBid, err := types.U128FromString(c.HighestBid.Amount)
 	if err != nil {
 		return errors.New("invalid winning bid amount in state")
 	}
 
-	nftArgs := map[string]string{
-		"receiver_id": c.HighestBid.Bidder,
-		"token_id":    c.TokenId,
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
+
+	c.Claimed = true
+	c.Settlement = SettlementPending
+
+	probeArgs := core.NftPayoutArgs{
+		TokenId:      c.TokenId,
+		Balance:      winningBid.String(),
+		MaxLenPayout: core.ProbeLenPayout,
+	}
+
+	callbackArgs := ClaimCallbackInput{
+		Winner: c.HighestBid.Bidder,
+		Amount: winningBid.String(),
+	}
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas130T := uint64(types.ONE_TERA_GAS * 130)
+
+	promise.CreateBatch(c.NftContract).
+		FunctionCall("nft_payout", probeArgs, zero, gas10T).
+		Then(currentAccount).
+		FunctionCall("payout_probe_callback", callbackArgs, zero, gas130T).
+		Value()
+
+	return nil
+}
+
+// PayoutProbeCallback sends the token once nft_payout has shown whether the
+// NFT contract supports NEP-199. With support, the token goes through
+// nft_transfer_payout so royalties are paid, and a payout with more than
+// core.MaxLenPayout receivers fails the settlement rather than skip them. Without
+// support, a plain nft_transfer pays the whole bid to the auctioneer.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *NftAuctionContract) PayoutProbeCallback(input ClaimCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
 	}
 
 	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
 	gas30T := uint64(types.ONE_TERA_GAS * 30)
+	gas50T := uint64(types.ONE_TERA_GAS * 50)
+	gas60T := uint64(types.ONE_TERA_GAS * 60)
+
+	if !result.Success {
+		env.LogString("nft_payout failed, the NFT contract has no payout support, settling with nft_transfer")
+
+		nftArgs := core.NftTransferArgs{
+			ReceiverId: input.Winner,
+			TokenId:    c.TokenId,
+			ApprovalId: c.ApprovalId,
+		}
+		input.PlainTransfer = true
+
+		promise.CreateBatch(c.NftContract).
+			FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T).
+			Then(current).
+			FunctionCall("claim_callback", input, zero, gas60T)
+		return false
+	}
+
+	if _, err := core.ParsePayout(result.Data); err != nil {
+		env.LogString("The payout of token " + c.TokenId + " can't be honored")
+		c.failSettlement(input)
+		return false
+	}
+
+	payoutArgs := core.NftTransferPayoutArgs{
+		ReceiverId:   input.Winner,
+		TokenId:      c.TokenId,
+		ApprovalId:   c.ApprovalId,
+		Balance:      input.Amount,
+		MaxLenPayout: core.MaxLenPayout,
+	}
+
+	promise.CreateBatch(c.NftContract).
+		FunctionCall("nft_transfer_payout", payoutArgs, oneYocto, gas50T).
+		Then(current).
+		FunctionCall("claim_callback", input, zero, gas60T)
+	return true
+}
+
+// failSettlement refunds the winner of a settlement that could not deliver
+// the token and marks it SettlementFailed.
+func (c *NftAuctionContract) failSettlement(input ClaimCallbackInput) {
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Failed to parse winning amount")
+		return
+	}
+
+	c.Claimed = false
+	c.Settlement = SettlementFailed
+
+	env.LogString("Token transfer failed, refunding " + input.Amount + " to " + input.Winner)
+	promise.CreateBatch(input.Winner).Transfer(amount)
+}
+
+// hasBids reports whether anyone has bid; until then the highest bid is the
+// placeholder Init puts in the contract's own name.
+func (c *NftAuctionContract) hasBids() bool {
+	currentAccount, _ := env.GetCurrentAccountId()
+	return c.HighestBid.Bidder != currentAccount
+}
+
+// settleNoSale ends an auction nobody bid on: there is nothing to pay out,
+// the token just goes back to the return address. A token listed by approval
+// never left the auctioneer, so it only moves if it has to go elsewhere.
+func (c *NftAuctionContract) settleNoSale() error {
+	c.Claimed = true
+	c.Settlement = SettlementPending
+
+	if c.ApprovalId != nil && c.ReturnAddress == c.Auctioneer {
+		c.finishNoSale()
+		return nil
+	}
+
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
 
-	promise.CreateBatch(c.Auctioneer).
-		Transfer(winningBid).
-		Then(c.NftContract).
+	nftArgs := core.NftTransferArgs{
+		ReceiverId: c.ReturnAddress,
+		TokenId:    c.TokenId,
+		ApprovalId: c.ApprovalId,
+	}
+
+	callbackArgs := NoSaleCallbackInput{
+		ReturnTo: c.ReturnAddress,
+	}
+
+	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas30T := uint64(types.ONE_TERA_GAS * 30)
+
+	promise.CreateBatch(c.NftContract).
 		FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T).
+		Then(currentAccount).
+		FunctionCall("no_sale_callback", callbackArgs, zero, gas10T).
 		Value()
 
 	return nil
 }
 
+func (c *NftAuctionContract) finishNoSale() {
+	c.Settlement = SettlementDone
+
+	env.LogString(core.NewEvent("no_sale", core.NoSaleEvent{
+		NftContract: c.NftContract,
+		TokenId:     c.TokenId,
+		ReturnedTo:  c.ReturnAddress,
+	}).String())
+}
+
+// NoSaleCallback completes a no-bid settlement once the token is back with
+// the return address. If the token could not be returned the auction is left
+// unclaimed so Claim can be called again.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *NftAuctionContract) NoSaleCallback(input NoSaleCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if !result.Success {
+		env.LogString("Returning the token to " + input.ReturnTo + " failed")
+		c.Claimed = false
+		c.Settlement = SettlementFailed
+		return false
+	}
+
+	c.finishNoSale()
+	return true
+}
+
+func (c *NftAuctionContract) isBundle() bool {
+	return len(c.Bundle) > 0
+}
+
+// bundleComplete reports whether every token of a bundle lot has been
+// escrowed. Single-token auctions are always complete.
+func (c *NftAuctionContract) bundleComplete() bool {
+	for _, item := range c.Bundle {
+		if !item.Escrowed {
+			return false
+		}
+	}
+	return true
+}
+
+// bundleReceiver is where the bundle tokens go: the winner, or the return
+// address when nobody bid.
+func (c *NftAuctionContract) bundleReceiver() string {
+	if c.hasBids() {
+		return c.HighestBid.Bidder
+	}
+	return c.ReturnAddress
+}
+
+// settleBundle delivers every escrowed token of a bundle lot with its own
+// nft_transfer and callback. The winning bid is paid to the auctioneer once
+// all of them have arrived. Bundles are settled with plain transfers, so
+// royalties are not paid on them.
+func (c *NftAuctionContract) settleBundle() error {
+	c.Claimed = true
+	c.Settlement = SettlementPending
+	return c.deliverBundle()
+}
+
+// RetryBundleDelivery sends again the bundle tokens whose delivery failed.
+//
+// @contract:mutating
+func (c *NftAuctionContract) RetryBundleDelivery() error {
+	if c.Settlement != SettlementPartial {
+		return errors.New("no bundle delivery to retry")
+	}
+
+	c.Settlement = SettlementPending
+	return c.deliverBundle()
+}
+
+func (c *NftAuctionContract) deliverBundle() error {
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
+
+	receiver := c.bundleReceiver()
+	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas30T := uint64(types.ONE_TERA_GAS * 30)
+
+	sent := 0
+	for i := range c.Bundle {
+		item := &c.Bundle[i]
+		if !item.Escrowed || item.Delivery == DeliveryDone {
+			continue
+		}
+
+		item.Delivery = DeliveryPending
+		sent++
+
+		nftArgs := core.NftTransferArgs{
+			ReceiverId: receiver,
+			TokenId:    item.TokenId,
+		}
+
+		callbackArgs := BundleCallbackInput{
+			Index:    i,
+			Receiver: receiver,
+		}
+
+		promise.CreateBatch(item.NftContract).
+			FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T).
+			Then(currentAccount).
+			FunctionCall("bundle_callback", callbackArgs, zero, gas10T)
+	}
+
+	if sent == 0 {
+		c.finishBundle()
+	}
+
+	return nil
+}
+
+// BundleCallback records the delivery of one bundle token. Once no token is
+// in flight any more the bundle is either settled or, if some deliveries
+// failed, left partially delivered for RetryBundleDelivery.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *NftAuctionContract) BundleCallback(input BundleCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if input.Index < 0 || input.Index >= len(c.Bundle) {
+		env.LogString("Unknown bundle index")
+		return false
+	}
+
+	item := &c.Bundle[input.Index]
+	if result.Success {
+		item.Delivery = DeliveryDone
+		env.LogString("Token " + item.TokenId + " delivered to " + input.Receiver)
+	} else {
+		item.Delivery = DeliveryFailed
+		env.LogString("Delivering token " + item.TokenId + " to " + input.Receiver + " failed")
+	}
+
+	failed := false
+	for _, other := range c.Bundle {
+		switch other.Delivery {
+		case DeliveryPending:
+			return result.Success
+		case DeliveryFailed:
+			failed = true
+		}
+	}
+
+	if failed {
+		c.Settlement = SettlementPartial
+		return result.Success
+	}
+
+	c.finishBundle()
+	return result.Success
+}
+
+func (c *NftAuctionContract) finishBundle() {
+	c.Settlement = SettlementDone
+
+	if !c.hasBids() {
+		for _, item := range c.Bundle {
+			if !item.Escrowed {
+				continue
+			}
+			env.LogString(core.NewEvent("no_sale", core.NoSaleEvent{
+				NftContract: item.NftContract,
+				TokenId:     item.TokenId,
+				ReturnedTo:  c.ReturnAddress,
+			}).String())
+		}
+		return
+	}
+
+	winningBid, err := types.U128FromString(c.HighestBid.Amount)
+	if err != nil {
+		env.LogString("Invalid winning bid amount in state")
+		return
+	}
+
+	env.LogString("Bundle delivered, paying " + winningBid.String() + " to " + c.Auctioneer)
+	c.payAuctioneer(winningBid)
+}
+
+// ClaimCallback pays out the winning bid once the token has been delivered.
+// If the transfer failed (for example because the approval was revoked or
+// the owner changed) the winner is refunded and the auction is marked
+// SettlementFailed. A failed nft_transfer_payout is not retried as a plain
+// transfer, which would skip the royalties.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *NftAuctionContract) ClaimCallback(input ClaimCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Failed to parse winning amount")
+		return false
+	}
+
+	if result.Success {
+		shares := []core.PayoutShare{{Receiver: c.Auctioneer, Amount: amount}}
+		if !input.PlainTransfer {
+			shares, err = core.PayoutShares(result.Data, amount, c.Auctioneer, current)
+			if err != nil {
+				env.LogString("Ignoring payout: " + err.Error())
+				shares = []core.PayoutShare{{Receiver: c.Auctioneer, Amount: amount}}
+			}
+		}
+
+		c.Settlement = SettlementDone
+
+		env.LogString("Token transferred to " + input.Winner)
+		for _, share := range shares {
+			env.LogString("Paying " + share.Amount.String() + " to " + share.Receiver)
+			if share.Receiver == c.Auctioneer {
+				c.payAuctioneer(share.Amount)
+				continue
+			}
+			promise.CreateBatch(share.Receiver).Transfer(share.Amount)
+		}
+		return true
+	}
+
+	c.failSettlement(input)
+	return false
+}
+
+// payAuctioneer pays amount to the auctioneer, less the protocol fee, which
+// goes to the fee account through collect_fee. Royalties are not charged.
+func (c *NftAuctionContract) payAuctioneer(amount types.Uint128) {
+	rest, err := core.CollectFee(c.ProtocolFee, amount)
+	if err != nil {
+		env.LogString("Failed to compute the protocol fee, paying it to the auctioneer")
+	}
+
+	promise.CreateBatch(c.Auctioneer).Transfer(rest)
+}
+
 // @contract:view
 func (c *NftAuctionContract) GetHighestBid() core.Bid {
 	return c.HighestBid
@@ -144,14 +903,54 @@ func (c *NftAuctionContract) GetClaimed() bool {
 	return c.Claimed
 }
 
+// @contract:view
+func (c *NftAuctionContract) GetSettlement() string {
+	return c.Settlement
+}
+
+// SelfDestruct deletes the auction account once it has been settled and the
+// grace period after its end has passed, sending the remaining balance to the
+// beneficiary. Only the factory that deployed the auction can call it, and it
+// gets the balance sent back so it can account for it.
+//
+// @contract:mutating
+func (c *NftAuctionContract) SelfDestruct(input core.SelfDestructArgs) (string, error) {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return "", errors.New("failed to get caller account")
+	}
+	if c.Factory == "" || caller != c.Factory {
+		return "", errors.New("only the factory can delete the auction")
+	}
+	if c.Settlement != SettlementDone {
+		return "", errors.New("the auction has not been settled")
+	}
+	if env.GetBlockTimeMs() < c.AuctionEndTime+core.SelfDestructGraceMs {
+		return "", errors.New("the grace period has not passed")
+	}
+	if input.Beneficiary == "" {
+		return "", errors.New("invalid beneficiary")
+	}
+
+	return core.DeleteSelf(input.Beneficiary)
+}
+
 // @contract:view
 func (c *NftAuctionContract) GetAuctionInfo() AuctionInfo {
 	return AuctionInfo{
 		HighestBid:     c.HighestBid,
 		AuctionEndTime: c.AuctionEndTime,
 		Auctioneer:     c.Auctioneer,
+		ReturnAddress:  c.ReturnAddress,
 		Claimed:        c.Claimed,
+		Settlement:     c.Settlement,
 		NftContract:    c.NftContract,
 		TokenId:        c.TokenId,
+		ApprovalId:     c.ApprovalId,
+		Bundle:         c.Bundle,
+		Verification:   c.Verification,
+		Metadata:       c.Metadata,
+		ProtocolFee:    c.ProtocolFee,
+		Factory:        c.Factory,
 	}
 }
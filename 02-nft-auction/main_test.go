@@ -1,9 +1,12 @@
 package main
 
 import (
+	"strconv"
 	"testing"
 
+	"github.com/emirsuyunasanov/near-auction-go/core"
 	"github.com/vlmoon99/near-sdk-go/env"
+	"github.com/vlmoon99/near-sdk-go/promise"
 	"github.com/vlmoon99/near-sdk-go/system"
 	"github.com/vlmoon99/near-sdk-go/types"
 )
@@ -44,9 +47,17 @@ func setupTest(t *testing.T) *NftAuctionContract {
 		NftContract: "nft.testnet",
 		TokenId:     "token-1",
 	})
+	verifyToken(t, c, `{"token_id":"token-1","owner_id":"auctioneer.testnet","metadata":{"title":"Sunset","media":"sunset.png","reference":null}}`)
 	return c
 }
 
+func verifyToken(t *testing.T, c *NftAuctionContract, token string) bool {
+	t.Helper()
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+	input := core.VerifyTokenCallbackInput{NftContract: c.NftContract, TokenId: c.TokenId}
+	return c.VerifyTokenCallback(input, promise.PromiseResult{Success: true, Data: []byte(token)})
+}
+
 func setBidder(t *testing.T, account string, depositLo uint64) {
 	t.Helper()
 	m := mockSys(t)
@@ -214,3 +225,614 @@ func TestNftAuction_FullLifecycle(t *testing.T) {
 		t.Errorf("winner should be bob, got %s", info.HighestBid.Bidder)
 	}
 }
+
+func approveToken(t *testing.T, c *NftAuctionContract, approvalId uint64) {
+	t.Helper()
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+	err := c.NftOnApprove(NftOnApproveInput{
+		TokenId:    "token-1",
+		OwnerId:    "auctioneer.testnet",
+		ApprovalId: approvalId,
+	})
+	if err != nil {
+		t.Fatalf("nft_on_approve failed: %v", err)
+	}
+}
+
+func TestNftAuction_NftOnApprove_Success(t *testing.T) {
+	c := setupTest(t)
+	approveToken(t, c, 7)
+
+	info := c.GetAuctionInfo()
+	if info.ApprovalId == nil || *info.ApprovalId != 7 {
+		t.Errorf("approval_id: want 7, got %v", info.ApprovalId)
+	}
+}
+
+func TestNftAuction_NftOnApprove_WrongContract(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "other-nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-1", OwnerId: "auctioneer.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for unsupported nft contract, got nil")
+	}
+	if err.Error() != "the nft contract is not supported" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNftAuction_NftOnApprove_WrongOwner(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-1", OwnerId: "mallory.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for approval by non-auctioneer, got nil")
+	}
+	if err.Error() != "only the auctioneer can approve the token" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if c.GetAuctionInfo().ApprovalId != nil {
+		t.Error("approval_id should not be stored")
+	}
+}
+
+func TestNftAuction_NftOnApprove_WrongToken(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-2", OwnerId: "auctioneer.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for unlisted token, got nil")
+	}
+}
+
+func TestNftAuction_Claim_ApprovalMode(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	approveToken(t, c, 3)
+
+	setBidder(t, "alice.testnet", 100)
+	_ = c.Bid()
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "100"}
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback should report a successful settlement")
+	}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("callback should report a failed settlement after a revoked approval")
+	}
+}
+
+func TestNftAuction_ClaimCallback_Unauthorized(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "mallory.testnet"
+
+	input := ClaimCallbackInput{Winner: "mallory.testnet", Amount: "100"}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback must only be accepted from the contract itself")
+	}
+}
+
+func TestNftAuction_ClaimCallback_NoPlainFallback(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "auction.testnet"
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "1000"}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("failed nft_transfer_payout should not settle the auction")
+	}
+	if c.GetSettlement() != SettlementFailed {
+		t.Errorf("a failed nft_transfer_payout should fail the settlement, got %q", c.GetSettlement())
+	}
+
+	input.PlainTransfer = true
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("successful plain nft_transfer should settle the auction")
+	}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("failed plain nft_transfer should refund the winner")
+	}
+}
+
+func TestNftAuction_PayoutProbeCallback(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "1000"}
+	m.PredecessorAccountIdSys = "mallory.testnet"
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback must only be accepted from the contract itself")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	c.Claimed = true
+	c.Settlement = SettlementPending
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("a contract without payout support should be settled with nft_transfer")
+	}
+	if c.GetSettlement() != SettlementPending {
+		t.Errorf("settlement: want %s, got %s", SettlementPending, c.GetSettlement())
+	}
+
+	payout := promise.PromiseResult{Success: true, Data: []byte(`{"payout":{"creator.testnet":"100","auctioneer.testnet":"900"}}`)}
+	if !c.PayoutProbeCallback(input, payout) {
+		t.Error("a supported payout should be sent with nft_transfer_payout")
+	}
+
+	many := `{"payout":{`
+	for i := 0; i <= int(core.MaxLenPayout); i++ {
+		if i > 0 {
+			many += ","
+		}
+		many += `"creator` + strconv.Itoa(i) + `.testnet":"1"`
+	}
+	many += `}}`
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: true, Data: []byte(many)}) {
+		t.Error("a payout with too many receivers should not be sent")
+	}
+	if c.GetSettlement() != SettlementFailed || c.GetClaimed() {
+		t.Errorf("expected a failed settlement, got %v/%s", c.GetClaimed(), c.GetSettlement())
+	}
+}
+
+func settleWithFailure(t *testing.T, c *NftAuctionContract) {
+	t.Helper()
+	m := mockSys(t)
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.Bid(); err != nil {
+		t.Fatalf("bid failed: %v", err)
+	}
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if c.GetSettlement() != SettlementPending {
+		t.Fatalf("settlement: want %s, got %s", SettlementPending, c.GetSettlement())
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "100", PlainTransfer: true}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Fatal("failed nft_transfer should not settle the auction")
+	}
+}
+
+func TestNftAuction_Settlement_Success(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	setBidder(t, "alice.testnet", 100)
+	_ = c.Bid()
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "100"}
+	payout := promise.PromiseResult{Success: true, Data: []byte(`{"payout":{"auction.testnet":"100"}}`)}
+	if !c.ClaimCallback(input, payout) {
+		t.Fatal("settlement should succeed")
+	}
+
+	info := c.GetAuctionInfo()
+	if !info.Claimed || info.Settlement != SettlementDone {
+		t.Errorf("expected claimed/settled, got %v/%s", info.Claimed, info.Settlement)
+	}
+}
+
+func TestNftAuction_Settlement_Failed(t *testing.T) {
+	c := setupTest(t)
+	settleWithFailure(t, c)
+
+	info := c.GetAuctionInfo()
+	if info.Settlement != SettlementFailed {
+		t.Errorf("settlement: want %s, got %s", SettlementFailed, info.Settlement)
+	}
+	if info.Claimed {
+		t.Error("a failed settlement should not leave the auction claimed")
+	}
+
+	err := c.Claim()
+	if err == nil {
+		t.Fatal("expected error when claiming a failed settlement, got nil")
+	}
+	if err.Error() != "settlement failed, the winner can retry it or the auctioneer can take the token back" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNftAuction_RetrySettlement(t *testing.T) {
+	c := setupTest(t)
+	settleWithFailure(t, c)
+
+	setBidder(t, "bob.testnet", 100)
+	if err := c.RetrySettlement(); err == nil {
+		t.Fatal("expected error for retry by non-winner, got nil")
+	}
+
+	setBidder(t, "alice.testnet", 50)
+	if err := c.RetrySettlement(); err == nil {
+		t.Fatal("expected error for retry without the winning bid, got nil")
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.RetrySettlement(); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+
+	info := c.GetAuctionInfo()
+	if !info.Claimed || info.Settlement != SettlementPending {
+		t.Errorf("expected claimed/pending after retry, got %v/%s", info.Claimed, info.Settlement)
+	}
+}
+
+func TestNftAuction_ReturnToken(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	m.PredecessorAccountIdSys = "auctioneer.testnet"
+	if err := c.ReturnToken(); err == nil || err.Error() != "settlement has not failed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	settleWithFailure(t, c)
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	if err := c.ReturnToken(); err == nil || err.Error() != "only the auctioneer can take the token back" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "auctioneer.testnet"
+	if err := c.ReturnToken(); err != nil {
+		t.Fatalf("return failed: %v", err)
+	}
+	if !c.GetClaimed() || c.GetSettlement() != SettlementPending {
+		t.Errorf("expected claimed/pending, got %v/%s", c.GetClaimed(), c.GetSettlement())
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if !c.NoSaleCallback(NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}, promise.PromiseResult{Success: true}) {
+		t.Fatal("returning the token should succeed")
+	}
+	if c.GetSettlement() != SettlementDone {
+		t.Errorf("settlement: want %s, got %s", SettlementDone, c.GetSettlement())
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.RetrySettlement(); err == nil {
+		t.Error("the winner should not retry once the token is back")
+	}
+}
+
+func TestNftAuction_RetrySettlement_NotFailed(t *testing.T) {
+	c := setupTest(t)
+
+	setBidder(t, "alice.testnet", 100)
+	err := c.RetrySettlement()
+	if err == nil {
+		t.Fatal("expected error for retry without a failed settlement, got nil")
+	}
+	if err.Error() != "settlement has not failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNftAuction_Init_ReturnAddress(t *testing.T) {
+	c := setupTest(t)
+	if got := c.GetAuctionInfo().ReturnAddress; got != "auctioneer.testnet" {
+		t.Errorf("return_address: want auctioneer.testnet, got %s", got)
+	}
+
+	c.Init(InitInput{
+		EndTime:       auctionEndTimeMs,
+		Auctioneer:    "auctioneer.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		ReturnAddress: "vault.testnet",
+	})
+	if got := c.GetAuctionInfo().ReturnAddress; got != "vault.testnet" {
+		t.Errorf("return_address: want vault.testnet, got %s", got)
+	}
+}
+
+func TestNftAuction_Claim_NoBids(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("no-sale claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true while the token is returned")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if !c.NoSaleCallback(NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}, promise.PromiseResult{Success: true}) {
+		t.Error("no-sale settlement should succeed once the token is returned")
+	}
+	if c.GetSettlement() != SettlementDone {
+		t.Errorf("settlement: want %s, got %s", SettlementDone, c.GetSettlement())
+	}
+}
+
+func TestNftAuction_Claim_NoBids_ReturnFailed(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	setBlockTime(t, afterEndNs)
+	_ = c.Claim()
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if c.NoSaleCallback(NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}, promise.PromiseResult{Success: false}) {
+		t.Error("no-sale settlement should fail when the token could not be returned")
+	}
+	if c.GetClaimed() {
+		t.Error("a failed return should leave the auction unclaimed")
+	}
+	if c.GetSettlement() != SettlementFailed {
+		t.Errorf("settlement: want %s, got %s", SettlementFailed, c.GetSettlement())
+	}
+
+	if err := c.Claim(); err != nil {
+		t.Fatalf("retrying the no-sale claim failed: %v", err)
+	}
+}
+
+func TestNftAuction_Claim_NoBids_ApprovalMode(t *testing.T) {
+	c := setupTest(t)
+	approveToken(t, c, 1)
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("no-sale claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true")
+	}
+	if c.GetSettlement() != SettlementDone {
+		t.Errorf("settlement: want %s, got %s", SettlementDone, c.GetSettlement())
+	}
+}
+
+func setupBundle(t *testing.T) *NftAuctionContract {
+	t.Helper()
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:    auctionEndTimeMs,
+		Auctioneer: "auctioneer.testnet",
+		Bundle: []BundleToken{
+			{NftContract: "nft.testnet", TokenId: "token-1"},
+			{NftContract: "art.testnet", TokenId: "token-2"},
+		},
+	})
+	return c
+}
+
+func escrowToken(t *testing.T, c *NftAuctionContract, nft, tokenId string) bool {
+	t.Helper()
+	mockSys(t).PredecessorAccountIdSys = nft
+	return c.NftOnTransfer(NftOnTransferInput{
+		SenderId:        "auctioneer.testnet",
+		PreviousOwnerId: "auctioneer.testnet",
+		TokenId:         tokenId,
+	})
+}
+
+func TestNftAuction_Bundle_Escrow(t *testing.T) {
+	c := setupBundle(t)
+
+	if !escrowToken(t, c, "nft.testnet", "token-9") {
+		t.Error("a token outside the bundle should be returned")
+	}
+	if escrowToken(t, c, "nft.testnet", "token-1") {
+		t.Error("a bundle token should be kept")
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	err := c.Bid()
+	if err == nil {
+		t.Fatal("expected error for bid on an incomplete bundle, got nil")
+	}
+	if err.Error() != "the bundle is not complete yet" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if escrowToken(t, c, "art.testnet", "token-2") {
+		t.Error("a bundle token should be kept")
+	}
+	setBidder(t, "alice.testnet", 100)
+	if err := c.Bid(); err != nil {
+		t.Fatalf("bid on a complete bundle failed: %v", err)
+	}
+}
+
+func TestNftAuction_Bundle_EscrowWrongOwner(t *testing.T) {
+	c := setupBundle(t)
+	mockSys(t).PredecessorAccountIdSys = "nft.testnet"
+
+	returned := c.NftOnTransfer(NftOnTransferInput{
+		SenderId:        "mallory.testnet",
+		PreviousOwnerId: "mallory.testnet",
+		TokenId:         "token-1",
+	})
+	if !returned {
+		t.Error("a token not sent by the auctioneer should be returned")
+	}
+	if c.GetAuctionInfo().Bundle[0].Escrowed {
+		t.Error("the token should not be escrowed")
+	}
+}
+
+func TestNftAuction_Bundle_PartialDelivery(t *testing.T) {
+	c := setupBundle(t)
+	m := mockSys(t)
+	escrowToken(t, c, "nft.testnet", "token-1")
+	escrowToken(t, c, "art.testnet", "token-2")
+
+	setBidder(t, "alice.testnet", 100)
+	_ = c.Bid()
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if !c.BundleCallback(BundleCallbackInput{Index: 0, Receiver: "alice.testnet"}, promise.PromiseResult{Success: true}) {
+		t.Error("delivered token should report success")
+	}
+	if c.GetSettlement() != SettlementPending {
+		t.Errorf("settlement: want %s, got %s", SettlementPending, c.GetSettlement())
+	}
+	if c.BundleCallback(BundleCallbackInput{Index: 1, Receiver: "alice.testnet"}, promise.PromiseResult{Success: false}) {
+		t.Error("failed token should report failure")
+	}
+	if c.GetSettlement() != SettlementPartial {
+		t.Errorf("settlement: want %s, got %s", SettlementPartial, c.GetSettlement())
+	}
+
+	if err := c.RetryBundleDelivery(); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	bundle := c.GetAuctionInfo().Bundle
+	if bundle[0].Delivery != DeliveryDone || bundle[1].Delivery != DeliveryPending {
+		t.Errorf("unexpected deliveries: %s/%s", bundle[0].Delivery, bundle[1].Delivery)
+	}
+
+	c.BundleCallback(BundleCallbackInput{Index: 1, Receiver: "alice.testnet"}, promise.PromiseResult{Success: true})
+	if c.GetSettlement() != SettlementDone {
+		t.Errorf("settlement: want %s, got %s", SettlementDone, c.GetSettlement())
+	}
+	if err := c.RetryBundleDelivery(); err == nil {
+		t.Error("expected error when retrying a settled bundle, got nil")
+	}
+}
+
+func TestNftAuction_VerifyToken(t *testing.T) {
+	c := setupTest(t)
+
+	info := c.GetAuctionInfo()
+	if info.Verification != VerificationDone {
+		t.Errorf("verification: want %s, got %s", VerificationDone, info.Verification)
+	}
+	if info.Metadata == nil || info.Metadata.Title != "Sunset" || info.Metadata.Media != "sunset.png" {
+		t.Errorf("unexpected metadata: %+v", info.Metadata)
+	}
+}
+
+func TestNftAuction_VerifyToken_WrongOwner(t *testing.T) {
+	c := setupTest(t)
+	c.Verification = VerificationPending
+
+	if verifyToken(t, c, `{"token_id":"token-1","owner_id":"mallory.testnet"}`) {
+		t.Error("a token owned by someone else should not be verified")
+	}
+	if c.GetAuctionInfo().Verification != VerificationFailed {
+		t.Errorf("verification: want %s, got %s", VerificationFailed, c.GetAuctionInfo().Verification)
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	err := c.Bid()
+	if err == nil {
+		t.Fatal("expected error for bid on an unverified token, got nil")
+	}
+	if err.Error() != "the token has not been verified" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := c.VerifyToken(); err != nil {
+		t.Fatalf("re-verification failed: %v", err)
+	}
+	if !verifyToken(t, c, `{"token_id":"token-1","owner_id":"auction.testnet"}`) {
+		t.Error("a token escrowed by the auction should be verified")
+	}
+}
+
+func TestNftAuction_VerifyToken_Missing(t *testing.T) {
+	c := setupTest(t)
+
+	if verifyToken(t, c, `null`) {
+		t.Error("a missing token should not be verified")
+	}
+	if err := c.VerifyToken(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	c.Verification = VerificationDone
+	if err := c.VerifyToken(); err == nil {
+		t.Error("expected error when verifying a verified token, got nil")
+	}
+}
+
+func TestNftAuction_VerifyToken_Deferred(t *testing.T) {
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:           auctionEndTimeMs,
+		Auctioneer:        "auctioneer.testnet",
+		NftContract:       "nft.testnet",
+		TokenId:           "token-1",
+		DeferVerification: true,
+	})
+	if c.Verification != VerificationDeferred {
+		t.Fatalf("verification: want %s, got %s", VerificationDeferred, c.Verification)
+	}
+
+	setBidder(t, "alice.testnet", 100)
+	if err := c.Bid(); err == nil || err.Error() != "the token has not been verified" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := c.VerifyToken(); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+	if err := c.VerifyToken(); err == nil {
+		t.Error("expected error when verifying a token twice, got nil")
+	}
+	if !verifyToken(t, c, `{"token_id":"token-1","owner_id":"auction.testnet"}`) {
+		t.Error("a token sent to the auction should be verified")
+	}
+}
+
+func TestNftAuction_SelfDestruct(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	beneficiary := core.SelfDestructArgs{Beneficiary: "creator.testnet"}
+
+	setBlockTime(t, (auctionEndTimeMs+core.SelfDestructGraceMs)*1_000_000)
+	m.PredecessorAccountIdSys = "auction.testnet"
+	c.Settlement = SettlementFailed
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "the auction has not been settled" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.Settlement = SettlementDone
+	m.PredecessorAccountIdSys = "alice.testnet"
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "only the factory can delete the auction" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if _, err := c.SelfDestruct(beneficiary); err != nil {
+		t.Fatalf("self destruct failed: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ use near_workspaces::types::NearToken;
 use serde_json::json;
 
 const WASM_PATH: &str = "../main.wasm";
+const NFT_WASM_PATH: &str = "../../test-contracts/nft/target/near/test_nft.wasm";
+const FT_WASM_PATH: &str = "../../test-contracts/ft/target/near/test_ft.wasm";
 const GAS: NearGas = NearGas::from_tgas(300);
 
 async fn deploy_and_init(
@@ -34,6 +36,100 @@ async fn deploy_and_init(
     Ok(contract)
 }
 
+/// Deploys the NEP-171 test contract and mints `token_id` to `owner`.
+async fn deploy_nft(
+    worker: &near_workspaces::Worker<near_workspaces::network::Sandbox>,
+    owner: &near_workspaces::Account,
+    token_id: &str,
+) -> anyhow::Result<near_workspaces::Contract> {
+    let nft = worker.dev_deploy(&std::fs::read(NFT_WASM_PATH)?).await?;
+    let result = nft
+        .call("new_default_meta")
+        .args_json(json!({ "owner_id": nft.id() }))
+        .transact()
+        .await?;
+    assert!(result.is_success(), "nft init failed: {:?}", result);
+    mint_nft(&nft, owner, token_id, json!({})).await?;
+    Ok(nft)
+}
+
+/// Mints `token_id` to `owner`; `royalties` maps receivers to basis points
+/// of every sale.
+async fn mint_nft(
+    nft: &near_workspaces::Contract,
+    owner: &near_workspaces::Account,
+    token_id: &str,
+    royalties: serde_json::Value,
+) -> anyhow::Result<()> {
+    let result = nft
+        .call("nft_mint")
+        .args_json(json!({
+            "token_id": token_id,
+            "token_owner_id": owner.id(),
+            "token_metadata": { "title": token_id },
+            "royalties": royalties
+        }))
+        .deposit(NearToken::from_millinear(100))
+        .transact()
+        .await?;
+    assert!(result.is_success(), "nft_mint failed: {:?}", result);
+    Ok(())
+}
+
+/// Deploys the NEP-141 test contract, minting `total_supply` to itself.
+async fn deploy_ft(
+    worker: &near_workspaces::Worker<near_workspaces::network::Sandbox>,
+    total_supply: &str,
+) -> anyhow::Result<near_workspaces::Contract> {
+    let ft = worker.dev_deploy(&std::fs::read(FT_WASM_PATH)?).await?;
+    let result = ft
+        .call("new_default_meta")
+        .args_json(json!({ "owner_id": ft.id(), "total_supply": total_supply }))
+        .transact()
+        .await?;
+    assert!(result.is_success(), "ft init failed: {:?}", result);
+    Ok(ft)
+}
+
+/// Registers `account_id` on the FT contract, paid by the contract itself.
+async fn register_ft(
+    ft: &near_workspaces::Contract,
+    account_id: &near_workspaces::AccountId,
+) -> anyhow::Result<()> {
+    let result = ft
+        .call("storage_deposit")
+        .args_json(json!({ "account_id": account_id }))
+        .deposit(NearToken::from_millinear(10))
+        .transact()
+        .await?;
+    assert!(result.is_success(), "storage_deposit failed: {:?}", result);
+    Ok(())
+}
+
+async fn ft_balance_of(
+    ft: &near_workspaces::Contract,
+    account_id: &near_workspaces::AccountId,
+) -> anyhow::Result<String> {
+    let result = ft
+        .call("ft_balance_of")
+        .args_json(json!({ "account_id": account_id }))
+        .gas(GAS)
+        .transact()
+        .await?;
+    Ok(result.json()?)
+}
+
+/// Fast-forwards the sandbox until its blocks are past `time_ms`.
+async fn wait_until(
+    worker: &near_workspaces::Worker<near_workspaces::network::Sandbox>,
+    time_ms: u64,
+) -> anyhow::Result<()> {
+    while worker.view_block().await?.timestamp() / 1_000_000 <= time_ms {
+        worker.fast_forward(100).await?;
+    }
+    Ok(())
+}
+
 #[tokio::main]
 async fn main() -> anyhow::Result<()> {
     let worker = near_workspaces::sandbox().await?;
@@ -47,7 +143,7 @@ async fn main() -> anyhow::Result<()> {
     let bob = worker.dev_create_account().await?;
     let auctioneer = worker.dev_create_account().await?;
     let ft_account = worker.dev_create_account().await?;
-    let nft_account = worker.dev_create_account().await?;
+    let nft_account = deploy_nft(&worker, &auctioneer, "token-1").await?;
 
     // ── Test 1: Init ──────────────────────────────────────────────
     println!("\n[1] Init with ft_contract, nft_contract, starting_price");
@@ -66,6 +162,7 @@ async fn main() -> anyhow::Result<()> {
     assert_eq!(info["ft_contract"].as_str().unwrap(), ft_account.id().as_str());
     assert_eq!(info["nft_contract"].as_str().unwrap(), nft_account.id().as_str());
     assert_eq!(info["token_id"].as_str().unwrap(), "token-1");
+    assert_eq!(info["verification"].as_str().unwrap(), "verified");
     assert_eq!(info["highest_bid"]["amount"].as_str().unwrap(), "1000");
     println!("  OK ft/nft contracts and starting_price stored");
 
@@ -113,8 +210,10 @@ async fn main() -> anyhow::Result<()> {
         .gas(GAS)
         .transact()
         .await?;
-    assert!(!result.is_success(), "Low FT bid should fail");
-    println!("  OK low FT bid correctly rejected");
+    assert!(result.is_success(), "Low FT bid should be refunded, not fail: {:?}", result);
+    let unused: String = result.json()?;
+    assert_eq!(unused, "500");
+    println!("  OK low FT bid correctly refunded");
 
     // ── Test 5: Wrong FT contract rejected ───────────────────────
     println!("\n[5] Wrong FT contract rejected — called by alice (not ft_account)");
@@ -156,8 +255,10 @@ async fn main() -> anyhow::Result<()> {
         .gas(GAS)
         .transact()
         .await?;
-    assert!(!result.is_success(), "Bid on ended auction should fail");
-    println!("  OK bid after end correctly rejected");
+    assert!(result.is_success(), "Bid on ended auction should be refunded, not fail: {:?}", result);
+    let unused: String = result.json()?;
+    assert_eq!(unused, "5000");
+    println!("  OK bid after end correctly refunded");
 
     // ── Test 8: Claim after end ───────────────────────────────────
     println!("\n[8] Claim after auction end");
@@ -170,21 +271,113 @@ async fn main() -> anyhow::Result<()> {
     println!("  logs: {:?}", result.logs());
     println!("  claim is_success={}", result.is_success());
 
+    // Nobody bid, so the token goes back to the auctioneer. It was never
+    // escrowed or approved, so the auction can't move it and the return fails.
     let result = ended.call("get_claimed").args_json(json!({})).gas(GAS).transact().await?;
     let claimed: bool = result.json()?;
-    assert!(claimed, "get_claimed should be true after claim");
-    println!("  OK claimed=true");
+    assert!(!claimed, "get_claimed should be false after a failed no-sale return");
+    println!("  OK claimed=false");
 
-    // ── Test 9: Double claim rejected ────────────────────────────
-    println!("\n[9] Double claim rejected");
+    // ── Test 9: No-sale claim can be retried ─────────────────────
+    println!("\n[9] No-sale claim can be retried");
     let result = alice
         .call(ended.id(), "claim")
         .args_json(json!({}))
         .gas(GAS)
         .transact()
         .await?;
-    assert!(!result.is_success(), "Double claim should fail");
-    println!("  OK double claim correctly rejected");
+    println!("  logs: {:?}", result.logs());
+    assert!(result.is_success(), "Retrying a no-sale claim should be allowed: {:?}", result);
+    println!("  OK no-sale claim retried");
+
+    // ── Test 10: Sale settles with royalties ─────────────────────
+    println!("\n[10] Sale in a real FT settles through nft_transfer_payout, artist gets 10%");
+    let ft = deploy_ft(&worker, "1000000").await?;
+    let artist = worker.dev_create_account().await?;
+    for account_id in [bob.id(), auctioneer.id(), artist.id()] {
+        register_ft(&ft, account_id).await?;
+    }
+    let result = ft
+        .call("ft_transfer")
+        .args_json(json!({ "receiver_id": bob.id(), "amount": "10000" }))
+        .deposit(NearToken::from_yoctonear(1))
+        .transact()
+        .await?;
+    assert!(result.is_success(), "ft_transfer to Bob failed: {:?}", result);
+
+    let mut royalties = serde_json::Map::new();
+    royalties.insert(artist.id().to_string(), json!(1000));
+    mint_nft(&nft_account, &auctioneer, "token-2", royalties.into()).await?;
+
+    let sale_end_ms = worker.view_block().await?.timestamp() / 1_000_000 + 30_000;
+    let sale = deploy_and_init(
+        &worker, &wasm, sale_end_ms,
+        auctioneer.id().as_str(),
+        ft.id().as_str(),
+        nft_account.id().as_str(),
+        "token-2", "1000",
+    ).await?;
+    register_ft(&ft, sale.id()).await?;
+
+    let result = auctioneer
+        .call(nft_account.id(), "nft_approve")
+        .args_json(json!({ "token_id": "token-2", "account_id": sale.id(), "msg": "" }))
+        .deposit(NearToken::from_millinear(10))
+        .gas(GAS)
+        .transact()
+        .await?;
+    println!("  logs: {:?}", result.logs());
+    assert!(result.is_success(), "nft_approve failed: {:?}", result);
+
+    let result = bob
+        .call(ft.id(), "ft_transfer_call")
+        .args_json(json!({ "receiver_id": sale.id(), "amount": "5000", "msg": "" }))
+        .deposit(NearToken::from_yoctonear(1))
+        .gas(GAS)
+        .transact()
+        .await?;
+    println!("  logs: {:?}", result.logs());
+    assert!(result.is_success(), "Bob bid failed: {:?}", result);
+    let used: String = result.json()?;
+    assert_eq!(used, "5000");
+
+    wait_until(&worker, sale_end_ms).await?;
+    let result = alice
+        .call(sale.id(), "claim")
+        .args_json(json!({}))
+        .gas(GAS)
+        .transact()
+        .await?;
+    println!("  logs: {:?}", result.logs());
+    assert!(result.is_success(), "claim failed: {:?}", result);
+
+    let result = nft_account
+        .call("nft_token")
+        .args_json(json!({ "token_id": "token-2" }))
+        .gas(GAS)
+        .transact()
+        .await?;
+    let token: serde_json::Value = result.json()?;
+    assert_eq!(token["owner_id"].as_str().unwrap(), bob.id().as_str());
+
+    let result = sale.call("get_settlement").args_json(json!({})).gas(GAS).transact().await?;
+    let settlement: String = result.json()?;
+    assert_eq!(settlement, "settled");
+
+    // FT royalties are credited on the auction and withdrawn by the artist.
+    let result = artist
+        .call(sale.id(), "withdraw_ft")
+        .args_json(json!({}))
+        .gas(GAS)
+        .transact()
+        .await?;
+    println!("  logs: {:?}", result.logs());
+    assert!(result.is_success(), "withdraw_ft failed: {:?}", result);
+
+    assert_eq!(ft_balance_of(&ft, artist.id()).await?, "500");
+    assert_eq!(ft_balance_of(&ft, auctioneer.id()).await?, "4500");
+    assert_eq!(ft_balance_of(&ft, bob.id()).await?, "5000");
+    println!("  OK token with Bob, artist paid 500, auctioneer 4500, settled");
 
     println!("\n✓ All 03-ft-auction integration tests passed");
     Ok(())
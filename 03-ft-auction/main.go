@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"io"
+	"strings"
 
 	"github.com/emirsuyunasanov/near-auction-go/core"
 	"github.com/vlmoon99/near-sdk-go/env"
@@ -9,23 +12,101 @@ import (
 	"github.com/vlmoon99/near-sdk-go/types"
 )
 
+// defaultStorageDeposit is what registering an account on a NEP-145 FT
+// contract usually costs (0.00125 NEAR).
+const defaultStorageDeposit = "1250000000000000000000"
+
+// maxTokenDecimals bounds the decimals of an accepted token so that 10^decimals
+// fits in a Uint128 with room for the conversion.
+const maxTokenDecimals = 24
+
+// NativeToken is the BidToken of bids paid in native NEAR through Bid.
+const NativeToken = "near"
+
+// Forms the auctioneer can ask to be paid in when the auction accepts both
+// native NEAR and wrapped NEAR. Without a preference the winning bid is paid
+// out in the form it was made in.
+const (
+	PayoutNative  = "near"
+	PayoutWrapped = "wnear"
+)
+
+// Settlement states. An auction that has not been claimed yet has no
+// settlement state. A refunded auction has paid the winning bid back and
+// settles once the token is back with the return address.
+const (
+	SettlementPending  = "pending"
+	SettlementDone     = "settled"
+	SettlementFailed   = "settlement_failed"
+	SettlementRefunded = "refunded"
+)
+
+// States of the two settlement legs: the NFT going to the winner and then the
+// winning bid going to the auctioneer.
+const (
+	LegPending = "pending"
+	LegDone    = "done"
+	LegFailed  = "failed"
+)
+
+// Verification states of the listed token. Bids are only accepted once the
+// token has been verified.
+const (
+	VerificationPending = "pending"
+	VerificationDone    = "verified"
+	VerificationFailed  = "verification_failed"
+)
+
 type AuctionInfo struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64   `json:"auction_end_time"`
-	Auctioneer     string   `json:"auctioneer"`
-	Claimed        bool     `json:"claimed"`
-	FtContract     string   `json:"ft_contract"`
-	NftContract    string   `json:"nft_contract"`
-	TokenId        string   `json:"token_id"`
+	HighestBid     core.Bid            `json:"highest_bid"`
+	AuctionEndTime uint64              `json:"auction_end_time"`
+	Auctioneer     string              `json:"auctioneer"`
+	ReturnAddress  string              `json:"return_address"`
+	Claimed        bool                `json:"claimed"`
+	Settlement     string              `json:"settlement"`
+	NftLeg         string              `json:"nft_leg"`
+	PaymentLeg     string              `json:"payment_leg"`
+	FtContract     string              `json:"ft_contract"`
+	AcceptedTokens []AcceptedToken     `json:"accepted_tokens"`
+	WrapContract   string              `json:"wrap_contract"`
+	PayoutForm     string              `json:"payout_form"`
+	BidToken       string              `json:"bid_token"`
+	BidTokenAmount string              `json:"bid_token_amount"`
+	MaxPrice       string              `json:"max_price"`
+	NftContract    string              `json:"nft_contract"`
+	TokenId        string              `json:"token_id"`
+	ApprovalId     *uint64             `json:"approval_id"`
+	Verification   string              `json:"verification"`
+	Metadata       *core.TokenMetadata `json:"metadata"`
+	StorageReserve string              `json:"storage_reserve"`
+	ProtocolFee    *core.ProtocolFee   `json:"protocol_fee"`
+	PaymentToken   string              `json:"payment_token"`
+	Factory        string              `json:"factory"`
 }
 
 type InitInput struct {
-	EndTime       uint64 `json:"end_time"`
-	Auctioneer    string `json:"auctioneer"`
-	FtContract    string `json:"ft_contract"`
-	NftContract   string `json:"nft_contract"`
-	TokenId       string `json:"token_id"`
-	StartingPrice string `json:"starting_price"`
+	EndTime        uint64            `json:"end_time"`
+	Auctioneer     string            `json:"auctioneer"`
+	FtContract     string            `json:"ft_contract"`
+	NftContract    string            `json:"nft_contract"`
+	TokenId        string            `json:"token_id"`
+	StartingPrice  string            `json:"starting_price"`
+	MaxPrice       string            `json:"max_price"`
+	ReturnAddress  string            `json:"return_address"`
+	StorageDeposit string            `json:"storage_deposit"`
+	AcceptedTokens []AcceptedToken   `json:"accepted_tokens"`
+	WrapContract   string            `json:"wrap_contract"`
+	PayoutForm     string            `json:"payout_form"`
+	ProtocolFee    *core.ProtocolFee `json:"protocol_fee"`
+}
+
+// AcceptedToken is an FT contract bids can be paid in. Rate is how many quote
+// units one whole token (10^Decimals of its smallest unit) is worth; bids,
+// StartingPrice and MaxPrice are compared in quote units.
+type AcceptedToken struct {
+	FtContract string `json:"ft_contract"`
+	Decimals   uint8  `json:"decimals"`
+	Rate       string `json:"rate"`
 }
 
 type FtOnTransferInput struct {
@@ -34,19 +115,160 @@ type FtOnTransferInput struct {
 	Msg      string `json:"msg"`
 }
 
+// Actions understood in the msg of ft_transfer_call.
+const (
+	ActionBid     = "bid"
+	ActionBidFor  = "bid_for"
+	ActionDeposit = "deposit"
+)
+
+// FtMsg is the JSON msg of ft_transfer_call:
+//
+//	{"action":"bid"}                          bid for the sender
+//	{"action":"bid","lot":"<token id>"}       bid for the sender on the given lot
+//	{"action":"bid_for","beneficiary":"<id>"} bid on behalf of another account
+//	{"action":"deposit"}                      credit the sender's balance
+type FtMsg struct {
+	Action      string `json:"action"`
+	Lot         string `json:"lot,omitempty"`
+	Beneficiary string `json:"beneficiary,omitempty"`
+}
+
+type NftOnApproveInput struct {
+	TokenId    string `json:"token_id"`
+	OwnerId    string `json:"owner_id"`
+	ApprovalId uint64 `json:"approval_id"`
+	Msg        string `json:"msg"`
+}
+
+type ClaimCallbackInput struct {
+	Winner        string `json:"winner"`
+	FtContract    string `json:"ft_contract"`
+	Amount        string `json:"amount"`
+	PlainTransfer bool   `json:"plain_transfer"`
+}
+
+type NoSaleCallbackInput struct {
+	ReturnTo string `json:"return_to"`
+}
+
+type TransferCallbackInput struct {
+	FtContract     string `json:"ft_contract"`
+	Account        string `json:"account"`
+	Amount         string `json:"amount"`
+	StorageDeposit string `json:"storage_deposit,omitempty"`
+	Payment        bool   `json:"payment,omitempty"`
+}
+
+type ConvertCallbackInput struct {
+	Account string `json:"account"`
+	Amount  string `json:"amount"`
+	To      string `json:"to"`
+	Payment bool   `json:"payment,omitempty"`
+}
+
+type RetrySettlementInput struct {
+	Refund bool `json:"refund"`
+}
+
+type StorageDepositArgs struct {
+	AccountId        string `json:"account_id"`
+	RegistrationOnly bool   `json:"registration_only"`
+}
+
+type GetBalanceInput struct {
+	AccountId  string `json:"account_id"`
+	FtContract string `json:"ft_contract"`
+}
+
+// WithdrawFtInput names the balance to send: AccountId's, the caller's by
+// default, of FtContract.
+type WithdrawFtInput struct {
+	FtContract string `json:"ft_contract"`
+	AccountId  string `json:"account_id"`
+}
+
 // @contract:state
 type FtAuctionContract struct {
-	HighestBid     core.Bid `json:"highest_bid"`
-	AuctionEndTime uint64   `json:"auction_end_time"`
-	Auctioneer     string   `json:"auctioneer"`
-	Claimed        bool     `json:"claimed"`
-	FtContract     string   `json:"ft_contract"`
-	NftContract    string   `json:"nft_contract"`
-	TokenId        string   `json:"token_id"`
+	HighestBid     core.Bid                     `json:"highest_bid"`
+	AuctionEndTime uint64                       `json:"auction_end_time"`
+	Auctioneer     string                       `json:"auctioneer"`
+	ReturnAddress  string                       `json:"return_address"`
+	Claimed        bool                         `json:"claimed"`
+	Settlement     string                       `json:"settlement"`
+	NftLeg         string                       `json:"nft_leg"`
+	PaymentLeg     string                       `json:"payment_leg"`
+	FtContract     string                       `json:"ft_contract"`
+	AcceptedTokens []AcceptedToken              `json:"accepted_tokens"`
+	WrapContract   string                       `json:"wrap_contract"`
+	PayoutForm     string                       `json:"payout_form"`
+	BidToken       string                       `json:"bid_token"`
+	BidTokenAmount string                       `json:"bid_token_amount"`
+	MaxPrice       string                       `json:"max_price"`
+	NftContract    string                       `json:"nft_contract"`
+	TokenId        string                       `json:"token_id"`
+	ApprovalId     *uint64                      `json:"approval_id,omitempty"`
+	Verification   string                       `json:"verification"`
+	Metadata       *core.TokenMetadata          `json:"metadata,omitempty"`
+	Balances       map[string]map[string]string `json:"balances,omitempty"`
+	StorageReserve string                       `json:"storage_reserve"`
+	StorageDeposit string                       `json:"storage_deposit"`
+	ProtocolFee    *core.ProtocolFee            `json:"protocol_fee,omitempty"`
+	AuctioneerPay  string                       `json:"auctioneer_pay,omitempty"`
+	PaymentToken   string                       `json:"payment_token,omitempty"`
+	Factory        string                       `json:"factory"`
 }
 
+// Init sets up the auction and looks the listed token up with nft_token.
+// Bids are accepted once the token is known to belong to the auctioneer.
+// The deposit attached to Init becomes the storage reserve that registers
+// payout and refund receivers on the FT contract; FundStorageReserve tops it
+// up later, for instance when a factory deployed the auction without one.
+//
+// AcceptedTokens defaults to FtContract alone, taken 1:1 as the quote unit.
+// When it is given, FtContract defaults to its first entry. Setting
+// WrapContract, which has to be accepted, also opens Bid to native NEAR at
+// the same rate as the wrapped token.
+//
 // @contract:init
 func (c *FtAuctionContract) Init(input InitInput) {
+	if fee := input.ProtocolFee; fee != nil && (fee.Account == "" || fee.Bps > core.FeeBpsDenominator) {
+		env.PanicStr("invalid protocol fee")
+		return
+	}
+
+	accepted := input.AcceptedTokens
+	if len(accepted) == 0 {
+		accepted = []AcceptedToken{{FtContract: input.FtContract, Decimals: 0, Rate: "1"}}
+	}
+	for i, token := range accepted {
+		if err := token.validate(); err != nil {
+			env.PanicStr(err.Error())
+			return
+		}
+		for _, other := range accepted[:i] {
+			if other.FtContract == token.FtContract {
+				env.PanicStr("token " + token.FtContract + " is listed twice")
+				return
+			}
+		}
+	}
+
+	if input.WrapContract != "" {
+		found := false
+		for _, token := range accepted {
+			found = found || token.FtContract == input.WrapContract
+		}
+		if !found {
+			env.PanicStr("wrap_contract must be an accepted token")
+			return
+		}
+	}
+	if input.PayoutForm != "" && input.PayoutForm != PayoutNative && input.PayoutForm != PayoutWrapped {
+		env.PanicStr("unknown payout_form " + input.PayoutForm)
+		return
+	}
+
 	currentAccount, _ := env.GetCurrentAccountId()
 	c.HighestBid = core.Bid{
 		Bidder: currentAccount,
@@ -54,33 +276,757 @@ func (c *FtAuctionContract) Init(input InitInput) {
 	}
 	c.AuctionEndTime = input.EndTime
 	c.Auctioneer = input.Auctioneer
+	c.ReturnAddress = input.ReturnAddress
+	if c.ReturnAddress == "" {
+		c.ReturnAddress = input.Auctioneer
+	}
 	c.Claimed = false
+	c.Settlement = ""
+	c.NftLeg = ""
+	c.PaymentLeg = ""
 	c.FtContract = input.FtContract
+	if c.FtContract == "" {
+		c.FtContract = accepted[0].FtContract
+	}
+	c.AcceptedTokens = accepted
+	c.WrapContract = input.WrapContract
+	c.PayoutForm = input.PayoutForm
+	c.BidToken = ""
+	c.BidTokenAmount = "0"
+	c.MaxPrice = input.MaxPrice
+	c.StorageDeposit = input.StorageDeposit
+	if c.StorageDeposit == "" {
+		c.StorageDeposit = defaultStorageDeposit
+	}
+	c.StorageReserve = "0"
+	if reserve, err := env.GetAttachedDeposit(); err == nil {
+		c.StorageReserve = reserve.String()
+	}
 	c.NftContract = input.NftContract
 	c.TokenId = input.TokenId
+	c.Metadata = nil
+	c.ProtocolFee = input.ProtocolFee
+	c.AuctioneerPay = ""
+	c.PaymentToken = ""
+	c.Factory, _ = env.GetPredecessorAccountID()
 	env.LogString("FT Auction initialized")
+
+	c.verifyToken()
 }
 
+// VerifyToken looks the listed token up again, for instance after a failed
+// verification once the auctioneer has received the token.
+//
 // @contract:mutating
-func (c *FtAuctionContract) FtOnTransfer(input FtOnTransferInput) (string, error) {
-	blockTime := env.GetBlockTimeMs()
-	if blockTime >= c.AuctionEndTime {
-		return "", errors.New("auction has ended")
+func (c *FtAuctionContract) VerifyToken() error {
+	if c.Verification != VerificationFailed {
+		return errors.New("the token does not need verification")
+	}
+
+	c.verifyToken()
+	return nil
+}
+
+func (c *FtAuctionContract) verifyToken() {
+	c.Verification = VerificationPending
+	core.VerifyToken(c.NftContract, c.TokenId)
+}
+
+// VerifyTokenCallback checks the nft_token result: the token has to exist
+// and be owned by the auctioneer, or already by the auction itself. Its
+// title, media and reference are kept for GetAuctionInfo.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) VerifyTokenCallback(input core.VerifyTokenCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if input.NftContract != c.NftContract || input.TokenId != c.TokenId {
+		env.LogString("Verification result is for another token")
+		return false
+	}
+
+	token, err := core.VerifiedToken(result, c.TokenId, c.Auctioneer, current)
+	if err != nil {
+		c.Verification = VerificationFailed
+		env.LogString("Token verification failed: " + err.Error())
+		return false
 	}
 
+	c.Verification = VerificationDone
+	c.Metadata = token.Metadata
+	env.LogString("Token " + c.TokenId + " verified, owned by " + token.OwnerId)
+	return true
+}
+
+// FtOnTransfer handles the tokens sent with ft_transfer_call. Msg selects
+// what to do with them, see FtMsg; an empty msg is a plain bid by the sender.
+//
+// It returns the unused amount that the FT contract gives back to the
+// sender: everything when the msg is invalid or the bid is rejected, the part
+// above MaxPrice when a bid is capped and "0" otherwise. Only calls that do
+// not come from an accepted FT contract fail.
+//
+// @contract:mutating
+func (c *FtAuctionContract) FtOnTransfer(input FtOnTransferInput) (string, error) {
 	ft, err := env.GetPredecessorAccountID()
 	if err != nil {
 		return "", errors.New("failed to get caller account")
 	}
-	if ft != c.FtContract {
+	token, ok := c.acceptedToken(ft)
+	if !ok {
 		return "", errors.New("the token is not supported")
 	}
 
-	newBid, err := types.U128FromString(input.Amount)
+	unused, err := c.handleTransfer(token, input)
+	if err != nil {
+		env.LogString("Refunding transfer from " + input.SenderId + ": " + err.Error())
+		return input.Amount, nil
+	}
+	return unused, nil
+}
+
+func (c *FtAuctionContract) handleTransfer(token AcceptedToken, input FtOnTransferInput) (string, error) {
+	msg, err := parseFtMsg(input.Msg)
+	if err != nil {
+		return "", err
+	}
+
+	switch msg.Action {
+	case ActionDeposit:
+		return c.deposit(token.FtContract, input.SenderId, input.Amount)
+	case ActionBidFor:
+		current, _ := env.GetCurrentAccountId()
+		if _, ok := c.acceptedToken(msg.Beneficiary); ok || msg.Beneficiary == current {
+			return "", errors.New("the beneficiary can't be the auction or a token contract")
+		}
+		return c.placeBid(token, msg.Beneficiary, input.Amount)
+	}
+
+	if msg.Lot != "" && msg.Lot != c.TokenId {
+		return "", errors.New("unknown lot " + msg.Lot)
+	}
+	return c.placeBid(token, input.SenderId, input.Amount)
+}
+
+// parseFtMsg strictly decodes an ft_on_transfer msg. Unknown fields, fields
+// that do not belong to the action and trailing data are all rejected.
+func parseFtMsg(raw string) (FtMsg, error) {
+	if raw == "" {
+		return FtMsg{Action: ActionBid}, nil
+	}
+
+	var msg FtMsg
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&msg); err != nil {
+		return msg, errors.New("malformed msg")
+	}
+	var rest json.RawMessage
+	if err := decoder.Decode(&rest); err != io.EOF {
+		return msg, errors.New("malformed msg")
+	}
+
+	switch msg.Action {
+	case ActionBid:
+		if msg.Beneficiary != "" {
+			return msg, errors.New("bid does not take a beneficiary")
+		}
+	case ActionBidFor:
+		if msg.Beneficiary == "" {
+			return msg, errors.New("bid_for requires a beneficiary")
+		}
+		if !core.ValidAccountId(msg.Beneficiary) {
+			return msg, errors.New("invalid beneficiary " + msg.Beneficiary)
+		}
+		if msg.Lot != "" {
+			return msg, errors.New("bid_for does not take a lot")
+		}
+	case ActionDeposit:
+		if msg.Beneficiary != "" || msg.Lot != "" {
+			return msg, errors.New("deposit takes no arguments")
+		}
+	default:
+		return msg, errors.New("unknown action " + msg.Action)
+	}
+
+	return msg, nil
+}
+
+// deposit credits the transferred tokens to the sender's balance. Deposits
+// close with the auction.
+func (c *FtAuctionContract) deposit(ft string, account string, amount string) (string, error) {
+	if env.GetBlockTimeMs() >= c.AuctionEndTime {
+		return "", errors.New("auction has ended")
+	}
+
+	value, err := types.U128FromString(amount)
+	if err != nil {
+		return "", errors.New("invalid deposit amount")
+	}
+
+	if err := c.credit(ft, account, value); err != nil {
+		return "", err
+	}
+	env.LogString("Deposited " + value.String() + " of " + ft + " for " + account)
+
+	return "0", nil
+}
+
+// credit adds amount of the ft token to the balance account can withdraw
+// with WithdrawFt.
+func (c *FtAuctionContract) credit(ft string, account string, amount types.Uint128) error {
+	balance := types.Uint128{Hi: 0, Lo: 0}
+	if current, ok := c.Balances[ft][account]; ok {
+		var err error
+		balance, err = types.U128FromString(current)
+		if err != nil {
+			return errors.New("invalid balance in state")
+		}
+	}
+
+	balance, err := balance.Add(amount)
+	if err != nil {
+		return errors.New("balance overflow")
+	}
+
+	if c.Balances == nil {
+		c.Balances = map[string]map[string]string{}
+	}
+	if c.Balances[ft] == nil {
+		c.Balances[ft] = map[string]string{}
+	}
+	c.Balances[ft][account] = balance.String()
+	return nil
+}
+
+// FundStorageReserve adds the attached deposit to the storage reserve.
+// Anyone can fund it; the deposit is not refundable.
+//
+// @contract:payable min_deposit=0
+func (c *FtAuctionContract) FundStorageReserve() error {
+	deposit, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
+	if deposit.Cmp(types.Uint128{Hi: 0, Lo: 0}) == 0 {
+		return errors.New("attach a deposit")
+	}
+
+	reserve, err := types.U128FromString(c.StorageReserve)
+	if err != nil {
+		return errors.New("invalid storage reserve in state")
+	}
+	reserve, err = reserve.Add(deposit)
+	if err != nil {
+		return errors.New("storage reserve overflow")
+	}
+	c.StorageReserve = reserve.String()
+
+	env.LogString("Storage reserve funded with " + deposit.String() + ", now " + c.StorageReserve)
+	return nil
+}
+
+// transferFt sends amount of the ft token to account. The account's registration on the FT
+// contract is checked first and, if it is missing, paid for from the storage
+// reserve (see EnsureStorageCallback). If the transfer still fails,
+// RefundCallback records the amount so it can be reclaimed with WithdrawFt,
+// or, for the settlement payment, marks the payment leg failed.
+func (c *FtAuctionContract) transferFt(ft string, account string, amount types.Uint128, payment bool) {
+	currentAccount, _ := env.GetCurrentAccountId()
+
+	callbackArgs := TransferCallbackInput{
+		FtContract: ft,
+		Account:    account,
+		Amount:     amount.String(),
+		Payment:    payment,
+	}
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+	gas35T := uint64(types.ONE_TERA_GAS * 35)
+
+	promise.CreateBatch(ft).
+		FunctionCall("storage_balance_of", map[string]string{"account_id": account}, zero, gas5T).
+		Then(currentAccount).
+		FunctionCall("ensure_storage_callback", callbackArgs, zero, gas35T)
+}
+
+// EnsureStorageCallback gets the storage_balance_of result for a pending
+// transfer. Unregistered accounts are registered with storage_deposit in the
+// same batch as the ft_transfer, paid from the storage reserve (see Init and
+// FundStorageReserve). Without enough reserve the amount goes straight to the
+// account's balance.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) EnsureStorageCallback(input TransferCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Invalid transfer amount")
+		return false
+	}
+
+	registered := !result.Success || (len(result.Data) > 0 && string(result.Data) != "null")
+
+	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+
+	ftArgs := map[string]string{
+		"receiver_id": input.Account,
+		"amount":      amount.String(),
+	}
+
+	if registered {
+		promise.CreateBatch(input.FtContract).
+			FunctionCall("ft_transfer", ftArgs, oneYocto, gas10T).
+			Then(current).
+			FunctionCall("refund_callback", input, zero, gas5T)
+		return true
+	}
+
+	reserve, err := types.U128FromString(c.StorageReserve)
+	if err != nil {
+		env.LogString("Invalid storage reserve in state")
+		return false
+	}
+	storageDeposit, err := types.U128FromString(c.StorageDeposit)
+	if err != nil {
+		env.LogString("Invalid storage deposit in state")
+		return false
+	}
+
+	if reserve.Cmp(storageDeposit) < 0 {
+		if err := c.credit(input.FtContract, input.Account, amount); err != nil {
+			env.LogString("Recording the transfer failed: " + err.Error())
+			return false
+		}
+		env.LogString("Storage reserve exhausted, " + amount.String() + " for " + input.Account + " can be withdrawn with withdraw_ft")
+		if input.Payment {
+			c.recordPayment(true)
+		}
+		return false
+	}
+
+	reserve, _ = reserve.Sub(storageDeposit)
+	c.StorageReserve = reserve.String()
+	input.StorageDeposit = storageDeposit.String()
+
+	storageArgs := StorageDepositArgs{
+		AccountId:        input.Account,
+		RegistrationOnly: true,
+	}
+
+	env.LogString("Registering " + input.Account + " on " + input.FtContract)
+	promise.CreateBatch(input.FtContract).
+		FunctionCall("storage_deposit", storageArgs, storageDeposit, gas5T).
+		FunctionCall("ft_transfer", ftArgs, oneYocto, gas10T).
+		Then(current).
+		FunctionCall("refund_callback", input, zero, gas5T)
+	return true
+}
+
+// RefundCallback credits a transfer that could not be delivered to the
+// account's balance. When the failed batch also registered the account, the
+// storage deposit came back to the contract and returns to the reserve.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) RefundCallback(input TransferCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if input.Payment {
+		c.recordPayment(result.Success)
+	}
+
+	if result.Success {
+		return true
+	}
+
+	if input.StorageDeposit != "" {
+		storageDeposit, _ := types.U128FromString(input.StorageDeposit)
+		reserve, _ := types.U128FromString(c.StorageReserve)
+		reserve, _ = reserve.Add(storageDeposit)
+		c.StorageReserve = reserve.String()
+	}
+
+	if input.Payment {
+		env.LogString("Paying " + input.Amount + " to " + input.Account + " failed, the settlement can be retried")
+		return false
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Invalid refund amount")
+		return false
+	}
+
+	if err := c.credit(input.FtContract, input.Account, amount); err != nil {
+		env.LogString("Recording the failed refund failed: " + err.Error())
+		return false
+	}
+	env.LogString("Refund of " + input.Amount + " to " + input.Account + " failed, it can be withdrawn with withdraw_ft")
+	return false
+}
+
+// WithdrawFt sends a whole balance of one token, FtContract if none is
+// given: deposits, royalties and refunds that could not be delivered
+// earlier. Once the auction has ended anyone can push a balance to its
+// owner, so that balances nobody withdraws don't keep the auction from being
+// deleted.
+//
+// @contract:mutating
+func (c *FtAuctionContract) WithdrawFt(input WithdrawFtInput) error {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+
+	account := caller
+	if input.AccountId != "" && input.AccountId != caller {
+		if env.GetBlockTimeMs() <= c.AuctionEndTime {
+			return errors.New("only the owner can withdraw a balance before the auction ends")
+		}
+		account = input.AccountId
+	}
+
+	ft := input.FtContract
+	if ft == "" {
+		ft = c.FtContract
+	}
+
+	current, ok := c.Balances[ft][account]
+	if !ok {
+		return errors.New("nothing to withdraw")
+	}
+
+	balance, err := types.U128FromString(current)
+	if err != nil {
+		return errors.New("invalid balance in state")
+	}
+
+	delete(c.Balances[ft], account)
+	if len(c.Balances[ft]) == 0 {
+		delete(c.Balances, ft)
+	}
+	env.LogString("Withdrawing " + balance.String() + " of " + ft + " to " + account)
+	c.transferFt(ft, account, balance, false)
+
+	return nil
+}
+
+// sendFunds pays amount of token to account, in native NEAR for NativeToken.
+func (c *FtAuctionContract) sendFunds(token string, account string, amount types.Uint128) {
+	if token == NativeToken {
+		promise.CreateBatch(account).Transfer(amount)
+		return
+	}
+	c.transferFt(token, account, amount, false)
+}
+
+// sendPayment pays the auctioneer's share in token and tracks it as the
+// payment leg of the settlement. The token is recorded so that a retry sends
+// the payment in the same form, without converting it again.
+func (c *FtAuctionContract) sendPayment(token string, amount types.Uint128) {
+	c.PaymentToken = token
+	if token == NativeToken {
+		promise.CreateBatch(c.Auctioneer).Transfer(amount)
+		c.recordPayment(true)
+		return
+	}
+	c.transferFt(token, c.Auctioneer, amount, true)
+}
+
+// recordPayment stores the outcome of the payment leg.
+func (c *FtAuctionContract) recordPayment(success bool) {
+	if success {
+		c.PaymentLeg = LegDone
+		c.Settlement = SettlementDone
+		return
+	}
+	c.PaymentLeg = LegFailed
+	c.Settlement = SettlementFailed
+}
+
+// collectFee sends the protocol fee on the auctioneer's share to the fee
+// account, in the token the bid was paid in, and returns what is left for
+// the auctioneer. A native NEAR fee goes through collect_fee; an FT fee is
+// transferred like any other payout and, if that fails, can be withdrawn by
+// the fee account with withdraw_ft.
+func (c *FtAuctionContract) collectFee(token string, amount types.Uint128) types.Uint128 {
+	if token == NativeToken {
+		rest, err := core.CollectFee(c.ProtocolFee, amount)
+		if err != nil {
+			env.LogString("Failed to compute the protocol fee, paying it to the auctioneer")
+		}
+		return rest
+	}
+
+	if c.ProtocolFee == nil || c.ProtocolFee.Bps == 0 {
+		return amount
+	}
+	fee, rest, err := core.SplitFee(amount, c.ProtocolFee.Bps)
+	if err != nil {
+		env.LogString("Failed to compute the protocol fee, paying it to the auctioneer")
+		return amount
+	}
+	if fee.Cmp(types.Uint128{Hi: 0, Lo: 0}) > 0 {
+		env.LogString("Paying a protocol fee of " + fee.String() + " " + token + " to " + c.ProtocolFee.Account)
+		c.transferFt(token, c.ProtocolFee.Account, fee, false)
+	}
+	return rest
+}
+
+// payRoyalty pays a royalty share of the winning bid. A native NEAR share is
+// a plain transfer, an FT share is credited to the receiver's balance rather
+// than sent: every FT transfer needs gas for the storage check and the
+// refund callback, which a payout with many receivers would run out of. The
+// balance is withdrawn with WithdrawFt, by the receiver or by anyone on
+// their behalf since the auction has ended.
+func (c *FtAuctionContract) payRoyalty(token string, share core.PayoutShare) {
+	if token == NativeToken {
+		env.LogString("Paying " + share.Amount.String() + " to " + share.Receiver)
+		promise.CreateBatch(share.Receiver).Transfer(share.Amount)
+		return
+	}
+
+	if err := c.credit(token, share.Receiver, share.Amount); err != nil {
+		env.LogString("Crediting the royalty of " + share.Receiver + " failed: " + err.Error())
+		return
+	}
+	env.LogString("Credited a royalty of " + share.Amount.String() + " to " + share.Receiver + ", it can be withdrawn with withdraw_ft")
+}
+
+// payAuctioneer pays the auctioneer's share, converting between native and
+// wrapped NEAR when the auctioneer prefers the other form.
+func (c *FtAuctionContract) payAuctioneer(token string, amount types.Uint128) {
+	current, _ := env.GetCurrentAccountId()
+	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas50T := uint64(types.ONE_TERA_GAS * 50)
+
+	callbackArgs := ConvertCallbackInput{
+		Account: c.Auctioneer,
+		Amount:  amount.String(),
+		Payment: true,
+	}
+
+	switch {
+	case token == NativeToken && c.PayoutForm == PayoutWrapped:
+		callbackArgs.To = PayoutWrapped
+		promise.CreateBatch(c.WrapContract).
+			FunctionCall("near_deposit", map[string]string{}, amount, gas10T).
+			Then(current).
+			FunctionCall("convert_callback", callbackArgs, zero, gas50T)
+	case token == c.WrapContract && c.PayoutForm == PayoutNative:
+		callbackArgs.To = PayoutNative
+		promise.CreateBatch(c.WrapContract).
+			FunctionCall("near_withdraw", map[string]string{"amount": amount.String()}, oneYocto, gas10T).
+			Then(current).
+			FunctionCall("convert_callback", callbackArgs, zero, gas50T)
+	default:
+		c.sendPayment(token, amount)
+	}
+}
+
+// ConvertCallback delivers a payment once it has been wrapped or unwrapped.
+// If the conversion failed the funds are still in their original form and
+// are delivered like that.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) ConvertCallback(input ConvertCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Invalid payment amount")
+		return false
+	}
+
+	wrapped := input.To == PayoutWrapped
+	if !result.Success {
+		env.LogString("Converting the payment to " + input.To + " failed, paying it unconverted")
+		wrapped = !wrapped
+	}
+
+	token := NativeToken
+	if wrapped {
+		token = c.WrapContract
+	}
+	if input.Payment {
+		c.sendPayment(token, amount)
+	} else {
+		c.sendFunds(token, input.Account, amount)
+	}
+	return result.Success
+}
+
+// acceptedToken looks up the whitelist entry of an FT contract.
+func (c *FtAuctionContract) acceptedToken(ft string) (AcceptedToken, bool) {
+	for _, token := range c.AcceptedTokens {
+		if token.FtContract == ft {
+			return token, true
+		}
+	}
+	return AcceptedToken{}, false
+}
+
+func (t AcceptedToken) validate() error {
+	if t.FtContract == "" {
+		return errors.New("accepted token without ft_contract")
+	}
+	if t.Decimals > maxTokenDecimals {
+		return errors.New("token " + t.FtContract + " has too many decimals")
+	}
+	rate, err := types.U128FromString(t.Rate)
+	if err != nil || rate.Cmp(types.Uint128{Hi: 0, Lo: 0}) == 0 {
+		return errors.New("token " + t.FtContract + " has an invalid rate")
+	}
+	return nil
+}
+
+// unit is the amount of the token's smallest unit in one whole token.
+func (t AcceptedToken) unit() types.Uint128 {
+	unit := types.U64ToUint128(1)
+	for i := uint8(0); i < t.Decimals; i++ {
+		unit, _ = unit.SafeMul64(10)
+	}
+	return unit
+}
+
+// toQuote converts an amount of the token into quote units, rounding down.
+func (t AcceptedToken) toQuote(amount types.Uint128) (types.Uint128, error) {
+	rate, err := types.U128FromString(t.Rate)
+	if err != nil {
+		return amount, errors.New("invalid rate in state")
+	}
+	value, err := amount.Mul(rate)
+	if err != nil {
+		return amount, errors.New("bid amount is too large")
+	}
+	return value.Div(t.unit())
+}
+
+// fromQuote converts quote units into an amount of the token, rounding down.
+func (t AcceptedToken) fromQuote(quote types.Uint128) (types.Uint128, error) {
+	rate, err := types.U128FromString(t.Rate)
+	if err != nil {
+		return quote, errors.New("invalid rate in state")
+	}
+	value, err := quote.Mul(t.unit())
+	if err != nil {
+		return quote, errors.New("amount is too large")
+	}
+	return value.Div(rate)
+}
+
+// Bid places a bid in native NEAR. It is worth as much as the same amount of
+// wrapped NEAR and is refunded in native NEAR when outbid.
+//
+// @contract:payable min_deposit=0
+func (c *FtAuctionContract) Bid() error {
+	if c.WrapContract == "" {
+		return errors.New("native NEAR bids are not accepted")
+	}
+
+	token, ok := c.acceptedToken(c.WrapContract)
+	if !ok {
+		return errors.New("invalid wrap contract in state")
+	}
+	token.FtContract = NativeToken
+
+	deposit, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
+
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+
+	unused, err := c.placeBid(token, caller, deposit.String())
+	if err != nil {
+		return err
+	}
+
+	if unused != "0" {
+		excess, _ := types.U128FromString(unused)
+		promise.CreateBatch(caller).Transfer(excess)
+	}
+	return nil
+}
+
+// placeBid bids amount of the token for bidder. The bid is compared in quote
+// units; a bid above MaxPrice only keeps the part of amount worth MaxPrice
+// and returns the rest. The outbid bidder is refunded in the token they used.
+func (c *FtAuctionContract) placeBid(token AcceptedToken, bidder string, amount string) (string, error) {
+	blockTime := env.GetBlockTimeMs()
+	if blockTime >= c.AuctionEndTime {
+		return "", errors.New("auction has ended")
+	}
+
+	if c.Verification != VerificationDone {
+		return "", errors.New("the token has not been verified")
+	}
+
+	tokenAmount, err := types.U128FromString(amount)
 	if err != nil {
 		return "", errors.New("invalid bid amount")
 	}
 
+	newBid, err := token.toQuote(tokenAmount)
+	if err != nil {
+		return "", err
+	}
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	unused := zero
+	if c.MaxPrice != "" {
+		maxPrice, err := types.U128FromString(c.MaxPrice)
+		if err != nil {
+			return "", errors.New("invalid max price in state")
+		}
+		if newBid.Cmp(maxPrice) > 0 {
+			capped, err := token.fromQuote(maxPrice)
+			if err != nil {
+				return "", err
+			}
+			unused, _ = tokenAmount.Sub(capped)
+			tokenAmount = capped
+			newBid, err = token.toQuote(tokenAmount)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
 	currentBid, err := types.U128FromString(c.HighestBid.Amount)
 	if err != nil {
 		return "", errors.New("invalid current bid amount in state")
@@ -91,27 +1037,67 @@ func (c *FtAuctionContract) FtOnTransfer(input FtOnTransferInput) (string, error
 	}
 
 	lastBidder := c.HighestBid.Bidder
-	lastBid := currentBid
+	lastToken := c.BidToken
+	lastAmount, err := types.U128FromString(c.BidTokenAmount)
+	if err != nil {
+		return "", errors.New("invalid bid token amount in state")
+	}
 
 	c.HighestBid = core.Bid{
-		Bidder: input.SenderId,
-		Amount: input.Amount,
+		Bidder: bidder,
+		Amount: newBid.String(),
 	}
+	c.BidToken = token.FtContract
+	c.BidTokenAmount = tokenAmount.String()
 
-	ftArgs := map[string]string{
-		"receiver_id": lastBidder,
-		"amount":      lastBid.String(),
+	if c.hasBidder(lastBidder) {
+		c.sendFunds(lastToken, lastBidder, lastAmount)
 	}
 
-	oneYocto := types.U64ToUint128(1)
-	gas30T := uint64(types.ONE_TERA_GAS * 30)
+	if unused.Cmp(zero) > 0 {
+		env.LogString("Bid capped at " + newBid.String() + ", returning " + unused.String())
+	}
+	return unused.String(), nil
+}
 
-	promise.CreateBatch(c.FtContract).
-		FunctionCall("ft_transfer", ftArgs, oneYocto, gas30T)
+// NftOnApprove lists the token without escrow: the auctioneer keeps the NFT
+// and approves this contract on the NFT contract, which then calls back here.
+//
+// @contract:mutating
+func (c *FtAuctionContract) NftOnApprove(input NftOnApproveInput) error {
+	nft, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+	if nft != c.NftContract {
+		return errors.New("the nft contract is not supported")
+	}
 
-	return "0", nil
+	if input.TokenId != c.TokenId {
+		return errors.New("the token is not listed in this auction")
+	}
+
+	if input.OwnerId != c.Auctioneer {
+		return errors.New("only the auctioneer can approve the token")
+	}
+
+	if c.Claimed {
+		return errors.New("auction has been claimed")
+	}
+
+	approvalId := input.ApprovalId
+	c.ApprovalId = &approvalId
+	env.LogString("Token approved with approval_id " + types.Uint64ToString(approvalId))
+
+	return nil
 }
 
+// Claim settles the auction in two tracked legs. The token goes out first,
+// through NEP-199 nft_transfer_payout when the NFT contract supports it so
+// royalties can be honored (see sendToken); the winning bid is only paid out
+// in the callback, once the NFT contract has moved the token. A leg that
+// fails leaves the settlement failed for RetrySettlement.
+//
 // @contract:mutating
 func (c *FtAuctionContract) Claim() error {
 	blockTime := env.GetBlockTimeMs()
@@ -123,30 +1109,345 @@ func (c *FtAuctionContract) Claim() error {
 		return errors.New("auction has been claimed")
 	}
 
+	if !c.hasBids() {
+		return c.settleNoSale()
+	}
+
 	c.Claimed = true
+	c.PaymentLeg = ""
+	return c.sendToken()
+}
 
-	ftArgs := map[string]string{
-		"receiver_id": c.Auctioneer,
-		"amount":      c.HighestBid.Amount,
+// sendToken starts the NFT leg by probing the NFT contract with nft_payout,
+// see PayoutProbeCallback.
+func (c *FtAuctionContract) sendToken() error {
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
+
+	c.Settlement = SettlementPending
+	c.NftLeg = LegPending
+
+	probeArgs := core.NftPayoutArgs{
+		TokenId:      c.TokenId,
+		Balance:      c.BidTokenAmount,
+		MaxLenPayout: core.ProbeLenPayout,
+	}
+
+	callbackArgs := ClaimCallbackInput{
+		Winner:     c.HighestBid.Bidder,
+		FtContract: c.BidToken,
+		Amount:     c.BidTokenAmount,
 	}
 
-	nftArgs := map[string]string{
-		"receiver_id": c.HighestBid.Bidder,
-		"token_id":    c.TokenId,
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas200T := uint64(types.ONE_TERA_GAS * 200)
+
+	promise.CreateBatch(c.NftContract).
+		FunctionCall("nft_payout", probeArgs, zero, gas10T).
+		Then(currentAccount).
+		FunctionCall("payout_probe_callback", callbackArgs, zero, gas200T).
+		Value()
+
+	return nil
+}
+
+// PayoutProbeCallback sends the token once nft_payout has shown whether the
+// NFT contract supports NEP-199. With support, the token goes through
+// nft_transfer_payout so royalties are paid, and a payout with more than
+// core.MaxLenPayout receivers fails the NFT leg rather than skip them. Without
+// support, a plain nft_transfer pays the whole bid to the auctioneer.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) PayoutProbeCallback(input ClaimCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
 	}
 
 	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
 	gas30T := uint64(types.ONE_TERA_GAS * 30)
+	gas40T := uint64(types.ONE_TERA_GAS * 40)
+	gas150T := uint64(types.ONE_TERA_GAS * 150)
+
+	if !result.Success {
+		env.LogString("nft_payout failed, the NFT contract has no payout support, settling with nft_transfer")
 
-	promise.CreateBatch(c.FtContract).
-		FunctionCall("ft_transfer", ftArgs, oneYocto, gas30T)
+		nftArgs := core.NftTransferArgs{
+			ReceiverId: input.Winner,
+			TokenId:    c.TokenId,
+			ApprovalId: c.ApprovalId,
+		}
+		input.PlainTransfer = true
+
+		promise.CreateBatch(c.NftContract).
+			FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T).
+			Then(current).
+			FunctionCall("claim_callback", input, zero, gas150T)
+		return false
+	}
+
+	if _, err := core.ParsePayout(result.Data); err != nil {
+		env.LogString("The payout of token " + c.TokenId + " can't be honored, the settlement can be retried or refunded")
+		c.NftLeg = LegFailed
+		c.Settlement = SettlementFailed
+		return false
+	}
+
+	payoutArgs := core.NftTransferPayoutArgs{
+		ReceiverId:   input.Winner,
+		TokenId:      c.TokenId,
+		ApprovalId:   c.ApprovalId,
+		Balance:      input.Amount,
+		MaxLenPayout: core.MaxLenPayout,
+	}
 
 	promise.CreateBatch(c.NftContract).
-		FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T)
+		FunctionCall("nft_transfer_payout", payoutArgs, oneYocto, gas40T).
+		Then(current).
+		FunctionCall("claim_callback", input, zero, gas150T)
+	return true
+}
+
+// RetrySettlement resumes a failed settlement. A failed payment is sent to
+// the auctioneer again, in the token it was last sent in. A failed NFT
+// delivery is sent again the way Claim sends it, unless the winner gives up
+// on the token with Refund and gets the winning bid back instead; the token
+// then goes back to the return address the way it does when nobody bid.
+//
+// @contract:mutating
+func (c *FtAuctionContract) RetrySettlement(input RetrySettlementInput) error {
+	if c.Settlement != SettlementFailed {
+		return errors.New("settlement has not failed")
+	}
+
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+
+	amount, err := types.U128FromString(c.BidTokenAmount)
+	if err != nil {
+		return errors.New("invalid bid token amount in state")
+	}
+
+	if c.PaymentLeg == LegFailed {
+		pay := amount
+		if c.AuctioneerPay != "" {
+			pay, err = types.U128FromString(c.AuctioneerPay)
+			if err != nil {
+				return errors.New("invalid auctioneer payment in state")
+			}
+		}
+		c.Settlement = SettlementPending
+		c.PaymentLeg = LegPending
+		if c.PaymentToken != "" {
+			// The payment has already been converted, if it had to be.
+			c.sendPayment(c.PaymentToken, pay)
+			return nil
+		}
+		c.payAuctioneer(c.BidToken, pay)
+		return nil
+	}
+
+	winner := c.HighestBid.Bidder
+	if caller != winner && caller != c.Auctioneer {
+		return errors.New("only the winner or the auctioneer can retry the settlement")
+	}
+
+	if input.Refund {
+		if caller != winner {
+			return errors.New("only the winner can take a refund")
+		}
+		c.Settlement = SettlementRefunded
+		env.LogString("Refunding " + amount.String() + " to " + winner)
+		c.sendFunds(c.BidToken, winner, amount)
+		c.NftLeg = LegPending
+		return c.returnToken()
+	}
+
+	return c.sendToken()
+}
+
+// ReturnToken tries again to send the token of a refunded auction back to
+// the return address.
+//
+// @contract:mutating
+func (c *FtAuctionContract) ReturnToken() error {
+	if c.Settlement != SettlementRefunded || c.NftLeg != LegFailed {
+		return errors.New("there is no token to return")
+	}
+
+	c.NftLeg = LegPending
+	return c.returnToken()
+}
+
+// hasBids reports whether anyone has bid; until then the highest bid is the
+// starting price Init puts in the contract's own name.
+func (c *FtAuctionContract) hasBids() bool {
+	return c.hasBidder(c.HighestBid.Bidder)
+}
+
+func (c *FtAuctionContract) hasBidder(bidder string) bool {
+	currentAccount, _ := env.GetCurrentAccountId()
+	return bidder != currentAccount
+}
+
+// settleNoSale ends an auction nobody bid on: there is nothing to pay out,
+// the token just goes back to the return address.
+func (c *FtAuctionContract) settleNoSale() error {
+	c.Claimed = true
+	return c.returnToken()
+}
+
+// returnToken sends the token to the return address. A token listed by
+// approval never left the auctioneer, so it only moves if it has to go
+// elsewhere.
+func (c *FtAuctionContract) returnToken() error {
+	if c.ApprovalId != nil && c.ReturnAddress == c.Auctioneer {
+		c.finishNoSale()
+		return nil
+	}
+
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
+
+	nftArgs := core.NftTransferArgs{
+		ReceiverId: c.ReturnAddress,
+		TokenId:    c.TokenId,
+		ApprovalId: c.ApprovalId,
+	}
+
+	callbackArgs := NoSaleCallbackInput{
+		ReturnTo: c.ReturnAddress,
+	}
+
+	oneYocto := types.U64ToUint128(1)
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas30T := uint64(types.ONE_TERA_GAS * 30)
+
+	promise.CreateBatch(c.NftContract).
+		FunctionCall("nft_transfer", nftArgs, oneYocto, gas30T).
+		Then(currentAccount).
+		FunctionCall("no_sale_callback", callbackArgs, zero, gas10T).
+		Value()
 
 	return nil
 }
 
+func (c *FtAuctionContract) finishNoSale() {
+	if c.Settlement == SettlementRefunded {
+		c.NftLeg = LegDone
+	}
+	c.Settlement = SettlementDone
+	env.LogString(core.NewEvent("no_sale", core.NoSaleEvent{
+		NftContract: c.NftContract,
+		TokenId:     c.TokenId,
+		ReturnedTo:  c.ReturnAddress,
+	}).String())
+}
+
+// NoSaleCallback completes a no-bid or refunded settlement once the token is
+// back with the return address. If a no-bid auction's token could not be
+// returned the auction is left unclaimed so Claim can be called again; a
+// refunded one is retried with ReturnToken.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) NoSaleCallback(input NoSaleCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if !result.Success {
+		env.LogString("Returning the token to " + input.ReturnTo + " failed")
+		if c.Settlement == SettlementRefunded {
+			c.NftLeg = LegFailed
+			return false
+		}
+		c.Claimed = false
+		return false
+	}
+
+	c.finishNoSale()
+	return true
+}
+
+// ClaimCallback pays out the winning bid once the token has been delivered,
+// royalty shares included (see payRoyalty). Only the auctioneer's share and
+// the protocol fee are sent from here, so the callback needs the same gas
+// whatever the number of royalty receivers. If the transfer failed (for
+// example because the approval was revoked or the owner changed) the NFT leg
+// is marked failed and the bid stays in escrow until RetrySettlement. A
+// failed nft_transfer_payout is not retried as a plain transfer, which would
+// skip the royalties.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FtAuctionContract) ClaimCallback(input ClaimCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil {
+		env.LogString("Failed to parse winning amount")
+		return false
+	}
+	if input.FtContract == "" {
+		// Callbacks scheduled before bids could use several tokens.
+		input.FtContract = c.FtContract
+	}
+
+	if result.Success {
+		shares := []core.PayoutShare{{Receiver: c.Auctioneer, Amount: amount}}
+		if !input.PlainTransfer {
+			shares, err = core.PayoutShares(result.Data, amount, c.Auctioneer, current)
+			if err != nil {
+				env.LogString("Ignoring payout: " + err.Error())
+				shares = []core.PayoutShare{{Receiver: c.Auctioneer, Amount: amount}}
+			}
+		}
+
+		env.LogString("Token transferred to " + input.Winner)
+		c.NftLeg = LegDone
+		c.PaymentLeg = LegPending
+		for _, share := range shares {
+			if share.Receiver == c.Auctioneer {
+				env.LogString("Paying " + share.Amount.String() + " to " + share.Receiver)
+				pay := c.collectFee(input.FtContract, share.Amount)
+				c.AuctioneerPay = pay.String()
+				c.PaymentToken = ""
+				c.payAuctioneer(input.FtContract, pay)
+				continue
+			}
+			c.payRoyalty(input.FtContract, share)
+		}
+		return true
+	}
+
+	env.LogString("Token transfer to " + input.Winner + " failed, the settlement can be retried")
+	c.NftLeg = LegFailed
+	c.Settlement = SettlementFailed
+
+	return false
+}
+
 // @contract:view
 func (c *FtAuctionContract) GetHighestBid() core.Bid {
 	return c.HighestBid
@@ -162,15 +1463,85 @@ func (c *FtAuctionContract) GetClaimed() bool {
 	return c.Claimed
 }
 
+// GetBalance returns the tokens account can withdraw from the auction, in
+// FtContract if no other token is given.
+//
+// @contract:view
+func (c *FtAuctionContract) GetBalance(input GetBalanceInput) string {
+	ft := input.FtContract
+	if ft == "" {
+		ft = c.FtContract
+	}
+	if balance, ok := c.Balances[ft][input.AccountId]; ok {
+		return balance
+	}
+	return "0"
+}
+
+// @contract:view
+func (c *FtAuctionContract) GetSettlement() string {
+	return c.Settlement
+}
+
+// SelfDestruct deletes the auction account once it has been settled, nothing
+// is owed on its ledger and the grace period after its end has passed,
+// sending the remaining balance to the beneficiary. A refunded auction only
+// counts as settled once its token is back with the return address, and
+// owed balances can be pushed out with WithdrawFt. Only the factory that
+// deployed the auction can call it, and it gets the balance sent back so it
+// can account for it.
+//
+// @contract:mutating
+func (c *FtAuctionContract) SelfDestruct(input core.SelfDestructArgs) (string, error) {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return "", errors.New("failed to get caller account")
+	}
+	if c.Factory == "" || caller != c.Factory {
+		return "", errors.New("only the factory can delete the auction")
+	}
+	if c.Settlement != SettlementDone {
+		return "", errors.New("the auction has not been settled")
+	}
+	if len(c.Balances) > 0 {
+		return "", errors.New("balances are still owed")
+	}
+	if env.GetBlockTimeMs() < c.AuctionEndTime+core.SelfDestructGraceMs {
+		return "", errors.New("the grace period has not passed")
+	}
+	if input.Beneficiary == "" {
+		return "", errors.New("invalid beneficiary")
+	}
+
+	return core.DeleteSelf(input.Beneficiary)
+}
+
 // @contract:view
 func (c *FtAuctionContract) GetAuctionInfo() AuctionInfo {
 	return AuctionInfo{
 		HighestBid:     c.HighestBid,
 		AuctionEndTime: c.AuctionEndTime,
 		Auctioneer:     c.Auctioneer,
+		ReturnAddress:  c.ReturnAddress,
 		Claimed:        c.Claimed,
+		Settlement:     c.Settlement,
+		NftLeg:         c.NftLeg,
+		PaymentLeg:     c.PaymentLeg,
 		FtContract:     c.FtContract,
+		AcceptedTokens: c.AcceptedTokens,
+		WrapContract:   c.WrapContract,
+		PayoutForm:     c.PayoutForm,
+		BidToken:       c.BidToken,
+		BidTokenAmount: c.BidTokenAmount,
+		MaxPrice:       c.MaxPrice,
 		NftContract:    c.NftContract,
 		TokenId:        c.TokenId,
+		ApprovalId:     c.ApprovalId,
+		Verification:   c.Verification,
+		Metadata:       c.Metadata,
+		StorageReserve: c.StorageReserve,
+		ProtocolFee:    c.ProtocolFee,
+		PaymentToken:   c.PaymentToken,
+		Factory:        c.Factory,
 	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"strconv"
 	"testing"
 
+	"github.com/emirsuyunasanov/near-auction-go/core"
 	"github.com/vlmoon99/near-sdk-go/env"
+	"github.com/vlmoon99/near-sdk-go/promise"
 	"github.com/vlmoon99/near-sdk-go/system"
 	"github.com/vlmoon99/near-sdk-go/types"
 )
@@ -46,9 +49,17 @@ func setupTest(t *testing.T) *FtAuctionContract {
 		TokenId:       "token-1",
 		StartingPrice: "10000",
 	})
+	verifyToken(t, c, `{"token_id":"token-1","owner_id":"auctioneer.testnet","metadata":{"title":"Sunset","media":"sunset.png","reference":"sunset.json"}}`)
 	return c
 }
 
+func verifyToken(t *testing.T, c *FtAuctionContract, token string) bool {
+	t.Helper()
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+	input := core.VerifyTokenCallbackInput{NftContract: c.NftContract, TokenId: c.TokenId}
+	return c.VerifyTokenCallback(input, promise.PromiseResult{Success: true, Data: []byte(token)})
+}
+
 func setFtBidder(t *testing.T, sender string, amount string) {
 	t.Helper()
 	m := mockSys(t)
@@ -119,16 +130,16 @@ func TestFtAuction_FtOnTransfer_TooLow(t *testing.T) {
 
 	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000", Msg: ""})
 
-	_, err := c.FtOnTransfer(FtOnTransferInput{
+	refund, err := c.FtOnTransfer(FtOnTransferInput{
 		SenderId: "bob.testnet",
 		Amount:   "5000",
 		Msg:      "",
 	})
-	if err == nil {
-		t.Fatal("expected error for bid too low, got nil")
+	if err != nil {
+		t.Fatalf("a low bid should be refunded, not fail: %v", err)
 	}
-	if err.Error() != "you must place a higher bid" {
-		t.Errorf("unexpected error: %v", err)
+	if refund != "5000" {
+		t.Errorf("expected full refund of 5000, got %s", refund)
 	}
 
 	bid := c.GetHighestBid()
@@ -142,13 +153,16 @@ func TestFtAuction_FtOnTransfer_BelowStartingPrice(t *testing.T) {
 	m := mockSys(t)
 	m.PredecessorAccountIdSys = "ft.testnet"
 
-	_, err := c.FtOnTransfer(FtOnTransferInput{
+	refund, err := c.FtOnTransfer(FtOnTransferInput{
 		SenderId: "alice.testnet",
 		Amount:   "5000",
 		Msg:      "",
 	})
-	if err == nil {
-		t.Fatal("expected error for bid below starting price, got nil")
+	if err != nil {
+		t.Fatalf("a bid below the starting price should be refunded, not fail: %v", err)
+	}
+	if refund != "5000" {
+		t.Errorf("expected full refund of 5000, got %s", refund)
 	}
 }
 
@@ -177,16 +191,19 @@ func TestFtAuction_FtOnTransfer_AfterEnd(t *testing.T) {
 	m := mockSys(t)
 	m.PredecessorAccountIdSys = "ft.testnet"
 
-	_, err := c.FtOnTransfer(FtOnTransferInput{
+	refund, err := c.FtOnTransfer(FtOnTransferInput{
 		SenderId: "alice.testnet",
 		Amount:   "50000",
 		Msg:      "",
 	})
-	if err == nil {
-		t.Fatal("expected error for bid after auction end, got nil")
+	if err != nil {
+		t.Fatalf("a late bid should be refunded, not fail: %v", err)
 	}
-	if err.Error() != "auction has ended" {
-		t.Errorf("unexpected error: %v", err)
+	if refund != "50000" {
+		t.Errorf("expected full refund of 50000, got %s", refund)
+	}
+	if c.GetHighestBid().Bidder != "auction.testnet" {
+		t.Error("a late bid should not be accepted")
 	}
 }
 
@@ -250,9 +267,9 @@ func TestFtAuction_FullLifecycle(t *testing.T) {
 	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000", Msg: ""})
 	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "60000", Msg: ""})
 
-	_, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000", Msg: ""})
-	if err == nil {
-		t.Fatal("alice's low re-bid should have failed")
+	refund, _ := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000", Msg: ""})
+	if refund != "50000" {
+		t.Fatal("alice's low re-bid should have been refunded")
 	}
 
 	bid := c.GetHighestBid()
@@ -263,9 +280,9 @@ func TestFtAuction_FullLifecycle(t *testing.T) {
 	setBlockTime(t, afterEndNs)
 
 	m.PredecessorAccountIdSys = "ft.testnet"
-	_, err = c.FtOnTransfer(FtOnTransferInput{SenderId: "charlie.testnet", Amount: "999999", Msg: ""})
-	if err == nil {
-		t.Fatal("bid after end should fail")
+	refund, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "charlie.testnet", Amount: "999999", Msg: ""})
+	if refund != "999999" {
+		t.Fatal("bid after end should be refunded")
 	}
 
 	if err := c.Claim(); err != nil {
@@ -280,3 +297,1027 @@ func TestFtAuction_FullLifecycle(t *testing.T) {
 		t.Errorf("winner should be bob, got %s", info.HighestBid.Bidder)
 	}
 }
+
+func approveToken(t *testing.T, c *FtAuctionContract, approvalId uint64) {
+	t.Helper()
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+	err := c.NftOnApprove(NftOnApproveInput{
+		TokenId:    "token-1",
+		OwnerId:    "auctioneer.testnet",
+		ApprovalId: approvalId,
+	})
+	if err != nil {
+		t.Fatalf("nft_on_approve failed: %v", err)
+	}
+}
+
+func TestFtAuction_NftOnApprove_Success(t *testing.T) {
+	c := setupTest(t)
+	approveToken(t, c, 7)
+
+	info := c.GetAuctionInfo()
+	if info.ApprovalId == nil || *info.ApprovalId != 7 {
+		t.Errorf("approval_id: want 7, got %v", info.ApprovalId)
+	}
+}
+
+func TestFtAuction_NftOnApprove_WrongContract(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "other-nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-1", OwnerId: "auctioneer.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for unsupported nft contract, got nil")
+	}
+	if err.Error() != "the nft contract is not supported" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFtAuction_NftOnApprove_WrongOwner(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-1", OwnerId: "mallory.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for approval by non-auctioneer, got nil")
+	}
+	if err.Error() != "only the auctioneer can approve the token" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if c.GetAuctionInfo().ApprovalId != nil {
+		t.Error("approval_id should not be stored")
+	}
+}
+
+func TestFtAuction_NftOnApprove_WrongToken(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "nft.testnet"
+
+	err := c.NftOnApprove(NftOnApproveInput{TokenId: "token-2", OwnerId: "auctioneer.testnet", ApprovalId: 1})
+	if err == nil {
+		t.Fatal("expected error for unlisted token, got nil")
+	}
+}
+
+func TestFtAuction_Claim_ApprovalMode(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	approveToken(t, c, 3)
+
+	m.PredecessorAccountIdSys = "ft.testnet"
+	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000", Msg: ""})
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "50000"}
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback should report a successful settlement")
+	}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("callback should report a failed settlement after a revoked approval")
+	}
+}
+
+func TestFtAuction_ClaimCallback_Unauthorized(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "mallory.testnet"
+
+	input := ClaimCallbackInput{Winner: "mallory.testnet", Amount: "50000"}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback must only be accepted from the contract itself")
+	}
+}
+
+func TestFtAuction_ClaimCallback_NoPlainFallback(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "auction.testnet"
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", Amount: "1000"}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("failed nft_transfer_payout should not settle the auction")
+	}
+	if c.GetSettlement() != SettlementFailed || c.GetAuctionInfo().NftLeg != LegFailed {
+		t.Errorf("a failed nft_transfer_payout should fail the NFT leg, got %s/%s", c.GetSettlement(), c.GetAuctionInfo().NftLeg)
+	}
+
+	input.PlainTransfer = true
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("successful plain nft_transfer should settle the auction")
+	}
+}
+
+func TestFtAuction_PayoutProbeCallback(t *testing.T) {
+	c := setupTest(t)
+	claimWithBid(t, c)
+	m := mockSys(t)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000"}
+	m.PredecessorAccountIdSys = "mallory.testnet"
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("callback must only be accepted from the contract itself")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("a contract without payout support should be settled with nft_transfer")
+	}
+	if c.GetAuctionInfo().NftLeg != LegPending {
+		t.Errorf("nft leg: want %s, got %s", LegPending, c.GetAuctionInfo().NftLeg)
+	}
+
+	payout := promise.PromiseResult{Success: true, Data: []byte(`{"payout":{"creator.testnet":"5000","auctioneer.testnet":"45000"}}`)}
+	if !c.PayoutProbeCallback(input, payout) {
+		t.Error("a supported payout should be sent with nft_transfer_payout")
+	}
+
+	many := `{"payout":{`
+	for i := 0; i <= int(core.MaxLenPayout); i++ {
+		if i > 0 {
+			many += ","
+		}
+		many += `"creator` + strconv.Itoa(i) + `.testnet":"1"`
+	}
+	many += `}}`
+	if c.PayoutProbeCallback(input, promise.PromiseResult{Success: true, Data: []byte(many)}) {
+		t.Error("a payout with too many receivers should not be sent")
+	}
+	if c.GetSettlement() != SettlementFailed || c.GetAuctionInfo().NftLeg != LegFailed {
+		t.Errorf("expected a failed NFT leg, got %s/%s", c.GetSettlement(), c.GetAuctionInfo().NftLeg)
+	}
+}
+
+func TestFtAuction_Init_ReturnAddress(t *testing.T) {
+	c := setupTest(t)
+	if got := c.GetAuctionInfo().ReturnAddress; got != "auctioneer.testnet" {
+		t.Errorf("return_address: want auctioneer.testnet, got %s", got)
+	}
+
+	c.Init(InitInput{
+		EndTime:       auctionEndTimeMs,
+		Auctioneer:    "auctioneer.testnet",
+		FtContract:    "ft.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		StartingPrice: "10000",
+		ReturnAddress: "vault.testnet",
+	})
+	if got := c.GetAuctionInfo().ReturnAddress; got != "vault.testnet" {
+		t.Errorf("return_address: want vault.testnet, got %s", got)
+	}
+}
+
+func TestFtAuction_Claim_NoBids(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("no-sale claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true while the token is returned")
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if !c.NoSaleCallback(NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}, promise.PromiseResult{Success: true}) {
+		t.Error("no-sale settlement should succeed once the token is returned")
+	}
+}
+
+func TestFtAuction_Claim_NoBids_ReturnFailed(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	setBlockTime(t, afterEndNs)
+	_ = c.Claim()
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if c.NoSaleCallback(NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}, promise.PromiseResult{Success: false}) {
+		t.Error("no-sale settlement should fail when the token could not be returned")
+	}
+	if c.GetClaimed() {
+		t.Error("a failed return should leave the auction unclaimed")
+	}
+
+	if err := c.Claim(); err != nil {
+		t.Fatalf("retrying the no-sale claim failed: %v", err)
+	}
+}
+
+func TestFtAuction_Claim_NoBids_ApprovalMode(t *testing.T) {
+	c := setupTest(t)
+	approveToken(t, c, 1)
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("no-sale claim failed: %v", err)
+	}
+	if !c.GetClaimed() {
+		t.Error("expected claimed=true")
+	}
+}
+
+func TestFtAuction_VerifyToken(t *testing.T) {
+	c := setupTest(t)
+
+	info := c.GetAuctionInfo()
+	if info.Verification != VerificationDone {
+		t.Errorf("verification: want %s, got %s", VerificationDone, info.Verification)
+	}
+	if info.Metadata == nil || info.Metadata.Title != "Sunset" || info.Metadata.Reference != "sunset.json" {
+		t.Errorf("unexpected metadata: %+v", info.Metadata)
+	}
+}
+
+func TestFtAuction_VerifyToken_WrongOwner(t *testing.T) {
+	c := setupTest(t)
+
+	if verifyToken(t, c, `{"token_id":"token-1","owner_id":"mallory.testnet"}`) {
+		t.Error("a token owned by someone else should not be verified")
+	}
+
+	setFtBidder(t, "alice.testnet", "20000")
+	refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "20000"})
+	if err != nil || refund != "20000" {
+		t.Fatalf("a bid on an unverified token should be refunded, got %s/%v", refund, err)
+	}
+
+	if err := c.VerifyToken(); err != nil {
+		t.Fatalf("re-verification failed: %v", err)
+	}
+	if c.GetAuctionInfo().Verification != VerificationPending {
+		t.Errorf("verification: want %s, got %s", VerificationPending, c.GetAuctionInfo().Verification)
+	}
+}
+
+func TestFtAuction_FtOnTransfer_Msg(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "ft.testnet"
+
+	refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "20000", Msg: `{"action":"bid","lot":"token-1"}`})
+	if err != nil || refund != "0" {
+		t.Fatalf("bid on lot failed: %s/%v", refund, err)
+	}
+
+	refund, err = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "30000", Msg: `{"action":"bid_for","beneficiary":"bob.testnet"}`})
+	if err != nil || refund != "0" {
+		t.Fatalf("bid_for failed: %s/%v", refund, err)
+	}
+	bid := c.GetHighestBid()
+	if bid.Bidder != "bob.testnet" || bid.Amount != "30000" {
+		t.Errorf("expected bob.testnet/30000, got %s/%s", bid.Bidder, bid.Amount)
+	}
+
+	refund, err = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "40000", Msg: "{\"action\":\"bid\"}\n"})
+	if err != nil || refund != "0" {
+		t.Errorf("trailing whitespace should be accepted: %s/%v", refund, err)
+	}
+}
+
+func TestFtAuction_FtOnTransfer_Deposit(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "ft.testnet"
+
+	for i := 0; i < 2; i++ {
+		refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "500", Msg: `{"action":"deposit"}`})
+		if err != nil || refund != "0" {
+			t.Fatalf("deposit failed: %s/%v", refund, err)
+		}
+	}
+
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "1000" {
+		t.Errorf("balance: want 1000, got %s", balance)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "bob.testnet"}); balance != "0" {
+		t.Errorf("balance: want 0, got %s", balance)
+	}
+	if c.GetHighestBid().Bidder != "auction.testnet" {
+		t.Error("a deposit should not place a bid")
+	}
+
+	setBlockTime(t, afterEndNs)
+	refund, _ := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "500", Msg: `{"action":"deposit"}`})
+	if refund != "500" {
+		t.Errorf("a deposit after the end should be refunded, got %s", refund)
+	}
+}
+
+func TestFtAuction_FtOnTransfer_InvalidMsg(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "ft.testnet"
+
+	msgs := []string{
+		`not json`,
+		`{"action":"sell"}`,
+		`{"action":"bid","price":"1"}`,
+		`{"action":"bid","beneficiary":"bob.testnet"}`,
+		`{"action":"bid","lot":"token-2"}`,
+		`{"action":"bid_for"}`,
+		`{"action":"deposit","lot":"token-1"}`,
+		`{"action":"bid"} {"action":"deposit"}`,
+		`{"action":"bid"}}`,
+		`{"action":"bid"}]`,
+		`{"action":"bid_for","beneficiary":"Bob.testnet"}`,
+		`{"action":"bid_for","beneficiary":"bob..testnet"}`,
+		`{"action":"bid_for","beneficiary":"auction.testnet"}`,
+		`{"action":"bid_for","beneficiary":"ft.testnet"}`,
+	}
+	for _, msg := range msgs {
+		refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "20000", Msg: msg})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", msg, err)
+		}
+		if refund != "20000" {
+			t.Errorf("%s: expected full refund, got %s", msg, refund)
+		}
+	}
+
+	if c.GetHighestBid().Bidder != "auction.testnet" {
+		t.Error("invalid messages should not place a bid")
+	}
+}
+
+func TestFtAuction_FtOnTransfer_MaxPrice(t *testing.T) {
+	c := setupTest(t)
+	c.MaxPrice = "60000"
+	mockSys(t).PredecessorAccountIdSys = "ft.testnet"
+
+	refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "75000"})
+	if err != nil {
+		t.Fatalf("ft_on_transfer failed: %v", err)
+	}
+	if refund != "15000" {
+		t.Errorf("expected the excess of 15000 back, got %s", refund)
+	}
+	bid := c.GetHighestBid()
+	if bid.Bidder != "alice.testnet" || bid.Amount != "60000" {
+		t.Errorf("expected alice/60000, got %s/%s", bid.Bidder, bid.Amount)
+	}
+
+	refund, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "90000"})
+	if refund != "90000" {
+		t.Errorf("a bid at a reached cap should be refunded in full, got %s", refund)
+	}
+}
+
+func TestFtAuction_RefundCallback_Failed(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+
+	input := TransferCallbackInput{FtContract: "ft.testnet", Account: "alice.testnet", Amount: "50000"}
+	if !c.RefundCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("a delivered refund should succeed")
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "0" {
+		t.Errorf("balance: want 0, got %s", balance)
+	}
+
+	c.RefundCallback(input, promise.PromiseResult{Success: false})
+	c.RefundCallback(input, promise.PromiseResult{Success: false})
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "100000" {
+		t.Errorf("balance: want 100000, got %s", balance)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "mallory.testnet"
+	if c.RefundCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("expected false for a callback from another account")
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "100000" {
+		t.Errorf("an outside call should not change the balance, got %s", balance)
+	}
+}
+
+func TestFtAuction_WithdrawFt(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "auction.testnet"
+	c.RefundCallback(TransferCallbackInput{FtContract: "ft.testnet", Account: "alice.testnet", Amount: "50000"}, promise.PromiseResult{Success: false})
+
+	m.PredecessorAccountIdSys = "bob.testnet"
+	err := c.WithdrawFt(WithdrawFtInput{})
+	if err == nil {
+		t.Fatal("expected error for withdrawing an empty balance, got nil")
+	}
+	if err.Error() != "nothing to withdraw" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	if err := c.WithdrawFt(WithdrawFtInput{}); err != nil {
+		t.Fatalf("withdraw failed: %v", err)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "0" {
+		t.Errorf("balance: want 0 after withdrawing, got %s", balance)
+	}
+}
+
+func TestFtAuction_WithdrawFt_ForAccount(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "auction.testnet"
+	c.RefundCallback(TransferCallbackInput{FtContract: "ft.testnet", Account: "alice.testnet", Amount: "50000"}, promise.PromiseResult{Success: false})
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	err := c.WithdrawFt(WithdrawFtInput{AccountId: "alice.testnet"})
+	if err == nil || err.Error() != "only the owner can withdraw a balance before the auction ends" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setBlockTime(t, afterEndNs)
+	if err := c.WithdrawFt(WithdrawFtInput{AccountId: "alice.testnet"}); err != nil {
+		t.Fatalf("pushing the balance failed: %v", err)
+	}
+	if len(c.Balances) != 0 {
+		t.Errorf("no balance should be left, got %v", c.Balances)
+	}
+}
+
+func TestFtAuction_Init_StorageReserve(t *testing.T) {
+	c := setupTest(t)
+	if c.GetAuctionInfo().StorageReserve != "0" {
+		t.Errorf("storage reserve: want 0, got %s", c.GetAuctionInfo().StorageReserve)
+	}
+
+	mockSys(t).AttachedDepositSys = types.Uint128{Hi: 0, Lo: 5000}
+	c.Init(InitInput{
+		EndTime:        auctionEndTimeMs,
+		Auctioneer:     "auctioneer.testnet",
+		FtContract:     "ft.testnet",
+		NftContract:    "nft.testnet",
+		TokenId:        "token-1",
+		StartingPrice:  "10000",
+		StorageDeposit: "2000",
+	})
+	if c.GetAuctionInfo().StorageReserve != "5000" {
+		t.Errorf("storage reserve: want 5000, got %s", c.GetAuctionInfo().StorageReserve)
+	}
+	if c.StorageDeposit != "2000" {
+		t.Errorf("storage deposit: want 2000, got %s", c.StorageDeposit)
+	}
+}
+
+func TestFtAuction_FundStorageReserve(t *testing.T) {
+	c := setupTest(t)
+	if err := c.FundStorageReserve(); err == nil {
+		t.Error("funding without a deposit should fail")
+	}
+
+	mockSys(t).AttachedDepositSys = types.Uint128{Hi: 0, Lo: 3000}
+	if err := c.FundStorageReserve(); err != nil {
+		t.Fatalf("FundStorageReserve: %v", err)
+	}
+	if err := c.FundStorageReserve(); err != nil {
+		t.Fatalf("FundStorageReserve: %v", err)
+	}
+	if c.GetAuctionInfo().StorageReserve != "6000" {
+		t.Errorf("storage reserve: want 6000, got %s", c.GetAuctionInfo().StorageReserve)
+	}
+}
+
+func TestFtAuction_EnsureStorageCallback(t *testing.T) {
+	c := setupTest(t)
+	c.StorageReserve = "3000"
+	c.StorageDeposit = "2000"
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+
+	input := TransferCallbackInput{FtContract: "ft.testnet", Account: "alice.testnet", Amount: "50000"}
+	registered := promise.PromiseResult{Success: true, Data: []byte(`{"total":"1250000000000000000000","available":"0"}`)}
+	if !c.EnsureStorageCallback(input, registered) {
+		t.Error("a registered account should be paid")
+	}
+	if c.StorageReserve != "3000" {
+		t.Errorf("a registered account should not use the reserve, got %s", c.StorageReserve)
+	}
+
+	unregistered := promise.PromiseResult{Success: true, Data: []byte(`null`)}
+	if !c.EnsureStorageCallback(input, unregistered) {
+		t.Error("an unregistered account should be registered and paid")
+	}
+	if c.StorageReserve != "1000" {
+		t.Errorf("storage reserve: want 1000, got %s", c.StorageReserve)
+	}
+
+	if c.EnsureStorageCallback(input, unregistered) {
+		t.Error("without reserve the transfer should not be made")
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "50000" {
+		t.Errorf("balance: want 50000, got %s", balance)
+	}
+}
+
+func TestFtAuction_RefundCallback_RestoresReserve(t *testing.T) {
+	c := setupTest(t)
+	c.StorageReserve = "1000"
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+
+	input := TransferCallbackInput{FtContract: "ft.testnet", Account: "alice.testnet", Amount: "50000", StorageDeposit: "2000"}
+	c.RefundCallback(input, promise.PromiseResult{Success: false})
+	if c.StorageReserve != "3000" {
+		t.Errorf("storage reserve: want 3000, got %s", c.StorageReserve)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "50000" {
+		t.Errorf("balance: want 50000, got %s", balance)
+	}
+}
+
+func setupMultiToken(t *testing.T) *FtAuctionContract {
+	t.Helper()
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:       auctionEndTimeMs,
+		Auctioneer:    "auctioneer.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		StartingPrice: "10000",
+		AcceptedTokens: []AcceptedToken{
+			{FtContract: "usdc.testnet", Decimals: 6, Rate: "1000000"},
+			{FtContract: "wrap.testnet", Decimals: 24, Rate: "3000000"},
+		},
+	})
+	verifyToken(t, c, `{"token_id":"token-1","owner_id":"auctioneer.testnet"}`)
+	return c
+}
+
+func TestFtAuction_Init_AcceptedTokens(t *testing.T) {
+	c := setupTest(t)
+	info := c.GetAuctionInfo()
+	if len(info.AcceptedTokens) != 1 || info.AcceptedTokens[0].FtContract != "ft.testnet" || info.AcceptedTokens[0].Rate != "1" {
+		t.Errorf("unexpected default accepted tokens: %+v", info.AcceptedTokens)
+	}
+
+	c = setupMultiToken(t)
+	if c.FtContract != "usdc.testnet" {
+		t.Errorf("ft_contract: want usdc.testnet, got %s", c.FtContract)
+	}
+}
+
+func TestFtAuction_FtOnTransfer_MultiToken(t *testing.T) {
+	c := setupMultiToken(t)
+	m := mockSys(t)
+
+	// 0.05 USDC is worth 50000 quote units.
+	m.PredecessorAccountIdSys = "usdc.testnet"
+	refund, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000"})
+	if err != nil || refund != "0" {
+		t.Fatalf("usdc bid failed: %s/%v", refund, err)
+	}
+
+	// 0.01 wNEAR is worth 30000 quote units, below alice's bid.
+	m.PredecessorAccountIdSys = "wrap.testnet"
+	refund, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "10000000000000000000000"})
+	if refund != "10000000000000000000000" {
+		t.Errorf("a lower normalized bid should be refunded, got %s", refund)
+	}
+
+	// 0.02 wNEAR is worth 60000 quote units.
+	refund, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "20000000000000000000000"})
+	if refund != "0" {
+		t.Fatalf("wrap bid failed: %s", refund)
+	}
+
+	info := c.GetAuctionInfo()
+	if info.HighestBid.Bidder != "bob.testnet" || info.HighestBid.Amount != "60000" {
+		t.Errorf("expected bob/60000, got %s/%s", info.HighestBid.Bidder, info.HighestBid.Amount)
+	}
+	if info.BidToken != "wrap.testnet" || info.BidTokenAmount != "20000000000000000000000" {
+		t.Errorf("unexpected bid token: %s/%s", info.BidToken, info.BidTokenAmount)
+	}
+
+	m.PredecessorAccountIdSys = "ft.testnet"
+	if _, err := c.FtOnTransfer(FtOnTransferInput{SenderId: "carol.testnet", Amount: "90000"}); err == nil {
+		t.Error("expected error for a token outside the whitelist, got nil")
+	}
+}
+
+func TestFtAuction_FtOnTransfer_MultiToken_MaxPrice(t *testing.T) {
+	c := setupMultiToken(t)
+	c.MaxPrice = "60000"
+	mockSys(t).PredecessorAccountIdSys = "wrap.testnet"
+
+	refund, _ := c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "30000000000000000000000"})
+	if refund != "10000000000000000000000" {
+		t.Errorf("expected the wNEAR above the cap back, got %s", refund)
+	}
+	if c.GetAuctionInfo().BidTokenAmount != "20000000000000000000000" {
+		t.Errorf("unexpected bid token amount: %s", c.GetAuctionInfo().BidTokenAmount)
+	}
+}
+
+func TestFtAuction_Deposit_PerToken(t *testing.T) {
+	c := setupMultiToken(t)
+	mockSys(t).PredecessorAccountIdSys = "wrap.testnet"
+
+	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "700", Msg: `{"action":"deposit"}`})
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet", FtContract: "wrap.testnet"}); balance != "700" {
+		t.Errorf("wrap balance: want 700, got %s", balance)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "alice.testnet"}); balance != "0" {
+		t.Errorf("usdc balance: want 0, got %s", balance)
+	}
+}
+
+func setupHybrid(t *testing.T, payoutForm string) *FtAuctionContract {
+	t.Helper()
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:       auctionEndTimeMs,
+		Auctioneer:    "auctioneer.testnet",
+		FtContract:    "wrap.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		StartingPrice: "10000",
+		WrapContract:  "wrap.testnet",
+		PayoutForm:    payoutForm,
+	})
+	verifyToken(t, c, `{"token_id":"token-1","owner_id":"auctioneer.testnet"}`)
+	return c
+}
+
+func TestFtAuction_Bid_NotEnabled(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "alice.testnet"
+	mockSys(t).AttachedDepositSys = types.Uint128{Hi: 0, Lo: 50000}
+
+	err := c.Bid()
+	if err == nil {
+		t.Fatal("expected error for a native bid without wrap contract, got nil")
+	}
+	if err.Error() != "native NEAR bids are not accepted" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFtAuction_Bid_Hybrid(t *testing.T) {
+	c := setupHybrid(t, "")
+	m := mockSys(t)
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 0, Lo: 50000}
+	if err := c.Bid(); err != nil {
+		t.Fatalf("native bid failed: %v", err)
+	}
+	info := c.GetAuctionInfo()
+	if info.BidToken != NativeToken || info.HighestBid.Amount != "50000" {
+		t.Errorf("expected a native bid of 50000, got %s/%s", info.BidToken, info.HighestBid.Amount)
+	}
+
+	m.PredecessorAccountIdSys = "wrap.testnet"
+	refund, _ := c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "50000"})
+	if refund != "50000" {
+		t.Errorf("an equal wNEAR bid should be refunded, got %s", refund)
+	}
+	refund, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "bob.testnet", Amount: "60000"})
+	if refund != "0" {
+		t.Fatalf("wNEAR bid failed: %s", refund)
+	}
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 0, Lo: 60000}
+	if err := c.Bid(); err == nil {
+		t.Error("expected error for an equal native bid, got nil")
+	}
+
+	info = c.GetAuctionInfo()
+	if info.HighestBid.Bidder != "bob.testnet" || info.BidToken != "wrap.testnet" {
+		t.Errorf("expected bob in wNEAR, got %s/%s", info.HighestBid.Bidder, info.BidToken)
+	}
+}
+
+func TestFtAuction_Init_HybridValidation(t *testing.T) {
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:      auctionEndTimeMs,
+		Auctioneer:   "auctioneer.testnet",
+		FtContract:   "ft.testnet",
+		NftContract:  "nft.testnet",
+		TokenId:      "token-1",
+		WrapContract: "wrap.testnet",
+	})
+	if c.WrapContract == "wrap.testnet" {
+		t.Error("a wrap contract outside the accepted tokens should be rejected")
+	}
+}
+
+func TestFtAuction_ConvertCallback(t *testing.T) {
+	c := setupHybrid(t, PayoutWrapped)
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+
+	input := ConvertCallbackInput{Account: "auctioneer.testnet", Amount: "50000", To: PayoutWrapped}
+	if !c.ConvertCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("a wrapped payment should succeed")
+	}
+	if c.ConvertCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("a failed conversion should be reported")
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "mallory.testnet"
+	if c.ConvertCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("expected false for a callback from another account")
+	}
+}
+
+func TestFtAuction_RetrySettlement_ConvertedPayment(t *testing.T) {
+	c := setupHybrid(t, PayoutWrapped)
+	m := mockSys(t)
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 0, Lo: 50000}
+	if err := c.Bid(); err != nil {
+		t.Fatalf("native bid failed: %v", err)
+	}
+	m.AttachedDepositSys = types.Uint128{Hi: 0, Lo: 0}
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: NativeToken, Amount: "50000", PlainTransfer: true}
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Fatal("delivering the token should succeed")
+	}
+	if c.PaymentToken != "" {
+		t.Fatalf("the payment should wait for its conversion, got %q", c.PaymentToken)
+	}
+
+	convert := ConvertCallbackInput{Account: "auctioneer.testnet", Amount: "50000", To: PayoutWrapped, Payment: true}
+	if !c.ConvertCallback(convert, promise.PromiseResult{Success: true}) {
+		t.Fatal("wrapping the payment should succeed")
+	}
+	if c.GetAuctionInfo().PaymentToken != "wrap.testnet" {
+		t.Errorf("payment token: want wrap.testnet, got %q", c.GetAuctionInfo().PaymentToken)
+	}
+
+	payment := TransferCallbackInput{FtContract: "wrap.testnet", Account: "auctioneer.testnet", Amount: "50000", Payment: true}
+	c.RefundCallback(payment, promise.PromiseResult{Success: false})
+	if c.GetSettlement() != SettlementFailed || c.GetAuctionInfo().PaymentLeg != LegFailed {
+		t.Fatalf("expected a failed payment, got %s/%s", c.GetSettlement(), c.GetAuctionInfo().PaymentLeg)
+	}
+
+	m.PredecessorAccountIdSys = "bob.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{}); err != nil {
+		t.Fatalf("retrying the payment failed: %v", err)
+	}
+	info := c.GetAuctionInfo()
+	if info.PaymentLeg != LegPending || info.PaymentToken != "wrap.testnet" {
+		t.Errorf("the retry should resend the wrapped payment, got %s/%q", info.PaymentLeg, info.PaymentToken)
+	}
+
+	m.PredecessorAccountIdSys = "auction.testnet"
+	c.RefundCallback(payment, promise.PromiseResult{Success: true})
+	if c.GetSettlement() != SettlementDone {
+		t.Errorf("expected settled, got %s", c.GetSettlement())
+	}
+}
+
+func claimWithBid(t *testing.T, c *FtAuctionContract) {
+	t.Helper()
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "ft.testnet"
+	_, _ = c.FtOnTransfer(FtOnTransferInput{SenderId: "alice.testnet", Amount: "50000"})
+
+	setBlockTime(t, afterEndNs)
+	if err := c.Claim(); err != nil {
+		t.Fatalf("claim failed: %v", err)
+	}
+	info := c.GetAuctionInfo()
+	if info.Settlement != SettlementPending || info.NftLeg != LegPending {
+		t.Fatalf("expected pending settlement, got %s/%s", info.Settlement, info.NftLeg)
+	}
+	m.PredecessorAccountIdSys = "auction.testnet"
+}
+
+func TestFtAuction_Settlement_Legs(t *testing.T) {
+	c := setupTest(t)
+	claimWithBid(t, c)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000", PlainTransfer: true}
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true}) {
+		t.Fatal("delivering the token should succeed")
+	}
+	info := c.GetAuctionInfo()
+	if info.NftLeg != LegDone || info.PaymentLeg != LegPending {
+		t.Errorf("unexpected legs: %s/%s", info.NftLeg, info.PaymentLeg)
+	}
+
+	payment := TransferCallbackInput{FtContract: "ft.testnet", Account: "auctioneer.testnet", Amount: "50000", Payment: true}
+	c.RefundCallback(payment, promise.PromiseResult{Success: true})
+	if c.GetSettlement() != SettlementDone || c.GetAuctionInfo().PaymentLeg != LegDone {
+		t.Errorf("expected settled, got %s/%s", c.GetSettlement(), c.GetAuctionInfo().PaymentLeg)
+	}
+}
+
+func TestFtAuction_ClaimCallback_RoyaltiesCredited(t *testing.T) {
+	c := setupTest(t)
+	claimWithBid(t, c)
+
+	payout := `{"payout":{`
+	for i := 0; i < int(core.MaxLenPayout); i++ {
+		if i > 0 {
+			payout += ","
+		}
+		payout += `"creator` + strconv.Itoa(i) + `.testnet":"1000"`
+	}
+	payout += `}}`
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000"}
+	if !c.ClaimCallback(input, promise.PromiseResult{Success: true, Data: []byte(payout)}) {
+		t.Fatal("delivering the token should succeed")
+	}
+
+	for i := 0; i < int(core.MaxLenPayout); i++ {
+		receiver := "creator" + strconv.Itoa(i) + ".testnet"
+		if balance := c.GetBalance(GetBalanceInput{AccountId: receiver}); balance != "1000" {
+			t.Errorf("royalty of %s: want 1000, got %s", receiver, balance)
+		}
+	}
+	info := c.GetAuctionInfo()
+	if info.NftLeg != LegDone || info.PaymentLeg != LegPending {
+		t.Errorf("unexpected legs: %s/%s", info.NftLeg, info.PaymentLeg)
+	}
+	if c.AuctioneerPay != "40000" {
+		t.Errorf("auctioneer pay: want 40000, got %s", c.AuctioneerPay)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "auctioneer.testnet"}); balance != "0" {
+		t.Errorf("the auctioneer's share should be sent, not credited, got %s", balance)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "bob.testnet"
+	if err := c.WithdrawFt(WithdrawFtInput{AccountId: "creator0.testnet"}); err != nil {
+		t.Fatalf("pushing a royalty failed: %v", err)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "creator0.testnet"}); balance != "0" {
+		t.Errorf("expected the royalty to be withdrawn, got %s", balance)
+	}
+}
+
+func TestFtAuction_RetrySettlement_Payment(t *testing.T) {
+	c := setupTest(t)
+	claimWithBid(t, c)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000", PlainTransfer: true}
+	c.ClaimCallback(input, promise.PromiseResult{Success: true})
+
+	payment := TransferCallbackInput{FtContract: "ft.testnet", Account: "auctioneer.testnet", Amount: "50000", Payment: true}
+	c.RefundCallback(payment, promise.PromiseResult{Success: false})
+	if c.GetSettlement() != SettlementFailed || c.GetAuctionInfo().PaymentLeg != LegFailed {
+		t.Fatalf("expected failed payment, got %s/%s", c.GetSettlement(), c.GetAuctionInfo().PaymentLeg)
+	}
+	if balance := c.GetBalance(GetBalanceInput{AccountId: "auctioneer.testnet"}); balance != "0" {
+		t.Errorf("a failed payment should not go to the balance, got %s", balance)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "bob.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{}); err != nil {
+		t.Fatalf("retrying the payment failed: %v", err)
+	}
+	if c.GetAuctionInfo().PaymentLeg != LegPending {
+		t.Errorf("payment leg: want %s, got %s", LegPending, c.GetAuctionInfo().PaymentLeg)
+	}
+	if err := c.RetrySettlement(RetrySettlementInput{}); err == nil {
+		t.Error("expected error when retrying a pending settlement, got nil")
+	}
+}
+
+func TestFtAuction_ProtocolFee(t *testing.T) {
+	c := setupTest(t)
+	c.Init(InitInput{
+		EndTime:       auctionEndTimeMs,
+		Auctioneer:    "auctioneer.testnet",
+		FtContract:    "ft.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		StartingPrice: "10000",
+		ProtocolFee:   &core.ProtocolFee{Account: "factory.testnet", Bps: core.FeeBpsDenominator + 1},
+	})
+	if c.ProtocolFee != nil {
+		t.Fatal("an invalid protocol fee should be rejected")
+	}
+
+	c.ProtocolFee = &core.ProtocolFee{Account: "factory.testnet", Bps: 250}
+	c.Verification = VerificationDone
+	claimWithBid(t, c)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000", PlainTransfer: true}
+	c.ClaimCallback(input, promise.PromiseResult{Success: true})
+	if c.AuctioneerPay != "48750" {
+		t.Errorf("auctioneer pay: want 48750 after the fee, got %s", c.AuctioneerPay)
+	}
+
+	payment := TransferCallbackInput{FtContract: "ft.testnet", Account: "auctioneer.testnet", Amount: "48750", Payment: true}
+	c.RefundCallback(payment, promise.PromiseResult{Success: false})
+	mockSys(t).PredecessorAccountIdSys = "auctioneer.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{}); err != nil {
+		t.Fatalf("retrying the payment failed: %v", err)
+	}
+	if c.AuctioneerPay != "48750" {
+		t.Errorf("a retried payment should not be charged again, got %s", c.AuctioneerPay)
+	}
+	if fee := c.GetAuctionInfo().ProtocolFee; fee == nil || fee.Bps != 250 {
+		t.Errorf("unexpected protocol fee in info: %+v", fee)
+	}
+}
+
+func TestFtAuction_RetrySettlement_Nft(t *testing.T) {
+	c := setupTest(t)
+	claimWithBid(t, c)
+
+	input := ClaimCallbackInput{Winner: "alice.testnet", FtContract: "ft.testnet", Amount: "50000", PlainTransfer: true}
+	if c.ClaimCallback(input, promise.PromiseResult{Success: false}) {
+		t.Fatal("a failed delivery should not succeed")
+	}
+	info := c.GetAuctionInfo()
+	if info.Settlement != SettlementFailed || info.NftLeg != LegFailed || !info.Claimed {
+		t.Fatalf("unexpected state: %s/%s/%v", info.Settlement, info.NftLeg, info.Claimed)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "bob.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{}); err == nil {
+		t.Error("expected error for retry by an outsider, got nil")
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "auctioneer.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{Refund: true}); err == nil {
+		t.Error("expected error for a refund requested by the auctioneer, got nil")
+	}
+	if err := c.RetrySettlement(RetrySettlementInput{}); err != nil {
+		t.Fatalf("retrying the delivery failed: %v", err)
+	}
+	if c.GetAuctionInfo().NftLeg != LegPending {
+		t.Errorf("nft leg: want %s, got %s", LegPending, c.GetAuctionInfo().NftLeg)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+	c.ClaimCallback(input, promise.PromiseResult{Success: false})
+
+	mockSys(t).PredecessorAccountIdSys = "alice.testnet"
+	if err := c.RetrySettlement(RetrySettlementInput{Refund: true}); err != nil {
+		t.Fatalf("refund failed: %v", err)
+	}
+	if c.GetSettlement() != SettlementRefunded {
+		t.Errorf("settlement: want %s, got %s", SettlementRefunded, c.GetSettlement())
+	}
+	if err := c.ReturnToken(); err == nil {
+		t.Error("expected error while the token is on its way back, got nil")
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+	noSale := NoSaleCallbackInput{ReturnTo: "auctioneer.testnet"}
+	c.NoSaleCallback(noSale, promise.PromiseResult{Success: false})
+	if info := c.GetAuctionInfo(); info.Settlement != SettlementRefunded || info.NftLeg != LegFailed || !info.Claimed {
+		t.Fatalf("a failed return should leave the refund open: %s/%s/%v", info.Settlement, info.NftLeg, info.Claimed)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "bob.testnet"
+	if err := c.ReturnToken(); err != nil {
+		t.Fatalf("retrying the return failed: %v", err)
+	}
+	mockSys(t).PredecessorAccountIdSys = "auction.testnet"
+	c.NoSaleCallback(noSale, promise.PromiseResult{Success: true})
+	if info := c.GetAuctionInfo(); info.Settlement != SettlementDone || info.NftLeg != LegDone {
+		t.Errorf("want a settled auction once the token is back, got %s/%s", info.Settlement, info.NftLeg)
+	}
+}
+
+func TestFtAuction_SelfDestruct(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	beneficiary := core.SelfDestructArgs{Beneficiary: "creator.testnet"}
+
+	c.Settlement = SettlementDone
+	m.PredecessorAccountIdSys = "auction.testnet"
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "the grace period has not passed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	setBlockTime(t, (auctionEndTimeMs+core.SelfDestructGraceMs)*1_000_000)
+	c.Balances = map[string]map[string]string{"ft.testnet": {"alice.testnet": "100"}}
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "balances are still owed" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.Balances = nil
+	c.Settlement = SettlementRefunded
+	if _, err := c.SelfDestruct(beneficiary); err == nil || err.Error() != "the auction has not been settled" {
+		t.Fatalf("a refunded auction still holding its token should not be deleted: %v", err)
+	}
+
+	c.Settlement = SettlementDone
+	if _, err := c.SelfDestruct(beneficiary); err != nil {
+		t.Fatalf("self destruct failed: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+// Global contracts let the factory publish a template once and deploy
+// auctions that reference its code hash instead of carrying the code.
+// near-sdk-go has no wrappers for these actions yet, so the factory binds the
+// host functions itself. They are variables so that tests can record them.
+var (
+	deployGlobalContract = promiseBatchActionDeployGlobalContract
+	useGlobalContract    = promiseBatchActionUseGlobalContract
+)
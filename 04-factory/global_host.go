@@ -0,0 +1,11 @@
+//go:build !wasm
+
+package main
+
+func promiseBatchActionDeployGlobalContract(promiseIndex uint64, code []byte) {
+	panic("global contracts are only available inside the NEAR runtime")
+}
+
+func promiseBatchActionUseGlobalContract(promiseIndex uint64, codeHash []byte) {
+	panic("global contracts are only available inside the NEAR runtime")
+}
@@ -0,0 +1,19 @@
+//go:build wasm
+
+package main
+
+import "unsafe"
+
+//go:wasmimport env promise_batch_action_deploy_global_contract
+func hostDeployGlobalContract(promiseIndex, codeLen, codePtr uint64)
+
+//go:wasmimport env promise_batch_action_use_global_contract
+func hostUseGlobalContract(promiseIndex, codeHashLen, codeHashPtr uint64)
+
+func promiseBatchActionDeployGlobalContract(promiseIndex uint64, code []byte) {
+	hostDeployGlobalContract(promiseIndex, uint64(len(code)), uint64(uintptr(unsafe.Pointer(&code[0]))))
+}
+
+func promiseBatchActionUseGlobalContract(promiseIndex uint64, codeHash []byte) {
+	hostUseGlobalContract(promiseIndex, uint64(len(codeHash)), uint64(uintptr(unsafe.Pointer(&codeHash[0]))))
+}
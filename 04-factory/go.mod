@@ -2,9 +2,12 @@ module github.com/emirsuyunasanov/near-auction-go/04-factory
 
 go 1.25.4
 
-require github.com/vlmoon99/near-sdk-go v0.1.1
-
 require (
-	github.com/mr-tron/base58 v1.2.0 // indirect
-	github.com/vlmoon99/jsonparser v0.0.1 // indirect
+	github.com/emirsuyunasanov/near-auction-go/core v0.0.0
+	github.com/mr-tron/base58 v1.2.0
+	github.com/vlmoon99/near-sdk-go v0.1.1
 )
+
+require github.com/vlmoon99/jsonparser v0.0.1 // indirect
+
+replace github.com/emirsuyunasanov/near-auction-go/core => ../core
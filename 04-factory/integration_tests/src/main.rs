@@ -4,6 +4,7 @@ use near_workspaces::types::NearToken;
 use serde_json::json;
 
 const WASM_PATH: &str = "../main.wasm";
+const FT_TEMPLATE_PATH: &str = "../../03-ft-auction/main.wasm";
 const GAS: NearGas = NearGas::from_tgas(300);
 
 async fn deploy_factory(
@@ -53,8 +54,9 @@ async fn main() -> anyhow::Result<()> {
 
     // ── Test 2: UpdateAuctionContract — unauthorized ───────────────
     println!("\n[2] UpdateAuctionContract — unauthorized caller");
-    let fake_wasm: Vec<u8> = b"\x00asm\x01\x00\x00\x00".to_vec();
-    let encoded = base64::engine::general_purpose::STANDARD.encode(&fake_wasm);
+    // Uploaded code is validated, so this has to be a real FT auction build.
+    let template_wasm = std::fs::read(FT_TEMPLATE_PATH)?;
+    let encoded = base64::engine::general_purpose::STANDARD.encode(&template_wasm);
 
     let result = user
         .call(factory.id(), "update_auction_contract")
@@ -79,7 +81,7 @@ async fn main() -> anyhow::Result<()> {
 
     let result = factory.call("get_code_size").args_json(json!({})).gas(GAS).transact().await?;
     let new_size: i64 = result.json()?;
-    assert_eq!(new_size, fake_wasm.len() as i64);
+    assert_eq!(new_size, template_wasm.len() as i64);
     println!("  OK code_size updated to {new_size}");
 
     // Restore original wasm for deploy tests
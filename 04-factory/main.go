@@ -1,131 +1,952 @@
 package main
 
 import (
+	"crypto/sha256"
 	_ "embed"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 
+	"github.com/emirsuyunasanov/near-auction-go/core"
+	"github.com/mr-tron/base58"
+	"github.com/vlmoon99/near-sdk-go/collections"
 	"github.com/vlmoon99/near-sdk-go/env"
 	"github.com/vlmoon99/near-sdk-go/promise"
 	"github.com/vlmoon99/near-sdk-go/types"
 )
 
-//go:embed auction.wasm
-var embeddedAuctionWasm []byte
+//go:embed templates/basic.wasm
+var embeddedBasicWasm []byte
+
+//go:embed templates/nft.wasm
+var embeddedNftWasm []byte
+
+//go:embed templates/ft.wasm
+var embeddedFtWasm []byte
 
 const nearPerStorageByte = uint64(10_000_000_000_000_000_000)
 
+// maxProtocolFeeBps caps the share of auction proceeds the factory can
+// charge.
+const maxProtocolFeeBps = uint64(1000)
+
+// accountStorageBytes is the storage a deployment pays for besides the code:
+// the new account itself and the auction's state.
+const accountStorageBytes = uint64(10_000)
+
+// codePrefix is the storage key prefix of the template binaries. They are
+// kept out of the contract state so that only deployments read them.
+const codePrefix = "c"
+
+// proposalTtlMs is how long a council proposal has to reach its approval
+// threshold before it expires.
+const proposalTtlMs = uint64(30 * 24 * 60 * 60 * 1000)
+
+// Paging of the registry views.
+const (
+	defaultPageLimit = uint64(50)
+	maxPageLimit     = uint64(100)
+)
+
+// registerGas is the gas of the callbacks that register a new auction:
+// writing the registry, the fee accounting and then either refunding the
+// surplus deposit or forwarding the listed token.
+const registerGas = uint64(types.ONE_TERA_GAS * 60)
+
+// maxSweepLimit bounds the auctions one SweepAuctions call deletes, so that
+// their self_destruct calls fit in its gas.
+const maxSweepLimit = uint64(10)
+
+// Beneficiaries of the balance left in a deleted auction.
+const (
+	ReclaimToCreator = "creator"
+	ReclaimToFactory = "factory"
+)
+
+// Auction templates embedded in the factory. Deployments that don't name a
+// kind get the FT auction, the only template older factories had.
+const (
+	KindBasic   = "basic"
+	KindNft     = "nft"
+	KindFt      = "ft"
+	defaultKind = KindFt
+)
+
 type DeployInput struct {
-	Name          string `json:"name"`
-	EndTime       uint64 `json:"end_time"`
-	Auctioneer    string `json:"auctioneer"`
-	FtContract    string `json:"ft_contract"`
-	NftContract   string `json:"nft_contract"`
-	TokenId       string `json:"token_id"`
-	StartingPrice string `json:"starting_price"`
+	Name          string                     `json:"name"`
+	Kind          string                     `json:"kind"`
+	EndTime       uint64                     `json:"end_time"`
+	Auctioneer    string                     `json:"auctioneer"`
+	FtContract    string                     `json:"ft_contract"`
+	NftContract   string                     `json:"nft_contract"`
+	TokenId       string                     `json:"token_id"`
+	StartingPrice string                     `json:"starting_price"`
+	Version       uint64                     `json:"version"`
+	Args          map[string]json.RawMessage `json:"args"`
+}
+
+// Template is an auction contract the factory can deploy: the releases of its
+// code, oldest first, the init arguments it takes and the methods, besides
+// init, its code must export. Variants, when given, are alternative sets of
+// optional arguments: a deployment gives exactly one of them, in full.
+type Template struct {
+	Releases []CodeRelease `json:"releases"`
+	Required []string      `json:"required"`
+	Optional []string      `json:"optional"`
+	Variants [][]string    `json:"variants"`
+	Methods  []string      `json:"methods"`
 }
 
-type AuctionInitArgs struct {
-	EndTime       uint64 `json:"end_time"`
-	Auctioneer    string `json:"auctioneer"`
-	FtContract    string `json:"ft_contract"`
-	NftContract   string `json:"nft_contract"`
-	TokenId       string `json:"token_id"`
-	StartingPrice string `json:"starting_price"`
+// CodeRelease is one uploaded version of a template. Hash is the base58
+// sha256 of the code, the same code hash NEAR reports for the accounts
+// running it.
+type CodeRelease struct {
+	Version    uint64 `json:"version"`
+	Hash       string `json:"hash"`
+	Size       uint64 `json:"size"`
+	Note       string `json:"note"`
+	Deprecated bool   `json:"deprecated"`
+	Published  bool   `json:"published"`
+}
+
+type TemplateInfo struct {
+	Kind     string     `json:"kind"`
+	Version  uint64     `json:"version"`
+	Hash     string     `json:"hash"`
+	CodeSize int        `json:"code_size"`
+	Required []string   `json:"required"`
+	Optional []string   `json:"optional"`
+	Variants [][]string `json:"variants"`
+	Methods  []string   `json:"methods"`
+}
+
+type TemplateInput struct {
+	Kind    string `json:"kind"`
+	Version uint64 `json:"version"`
+}
+
+type PublishCallbackInput struct {
+	Kind    string `json:"kind"`
+	Version uint64 `json:"version"`
+}
+
+type DeprecateInput struct {
+	Kind       string `json:"kind"`
+	Version    uint64 `json:"version"`
+	Deprecated bool   `json:"deprecated"`
 }
 
 type DeployCallbackInput struct {
-	Account  string `json:"account"`
-	User     string `json:"user"`
-	Attached string `json:"attached"`
+	Account     string `json:"account"`
+	User        string `json:"user"`
+	Attached    string `json:"attached"`
+	Surplus     string `json:"surplus"`
+	Fee         string `json:"fee"`
+	Kind        string `json:"kind"`
+	Auctioneer  string `json:"auctioneer"`
+	NftContract string `json:"nft_contract"`
+	FtContract  string `json:"ft_contract"`
+	TokenId     string `json:"token_id"`
+	EndTime     uint64 `json:"end_time"`
+	CodeVersion uint64 `json:"code_version"`
+	CodeHash    string `json:"code_hash"`
 }
 
+// AuctionRecord is the registry entry of an auction the factory deployed.
+type AuctionRecord struct {
+	Account     string `json:"account"`
+	Kind        string `json:"kind"`
+	Auctioneer  string `json:"auctioneer"`
+	Creator     string `json:"creator"`
+	NftContract string `json:"nft_contract"`
+	FtContract  string `json:"ft_contract"`
+	TokenId     string `json:"token_id"`
+	EndTime     uint64 `json:"end_time"`
+	CodeVersion uint64 `json:"code_version"`
+	CodeHash    string `json:"code_hash"`
+	Reclaimed   bool   `json:"reclaimed"`
+}
+
+type GetAuctionsInput struct {
+	From  uint64 `json:"from"`
+	Limit uint64 `json:"limit"`
+}
+
+type GetAuctionsByAuctioneerInput struct {
+	Auctioneer string `json:"auctioneer"`
+	From       uint64 `json:"from"`
+	Limit      uint64 `json:"limit"`
+}
+
+// UpdateCodeInput releases a new version of a template. A kind the factory
+// doesn't know yet is added as a new template, which needs its Required
+// arguments and the Methods its code must export.
 type UpdateCodeInput struct {
+	Kind     string     `json:"kind"`
+	Code     string     `json:"code"`
+	Note     string     `json:"note"`
+	Required []string   `json:"required"`
+	Optional []string   `json:"optional"`
+	Variants [][]string `json:"variants"`
+	Methods  []string   `json:"methods"`
+}
+
+// ListingMsg is the msg of an nft_transfer_call to the factory, which lists
+// the token in a new NFT auction. The deployment is paid from the sender's
+// prepaid deposit, up to MaxCost when it is given.
+type ListingMsg struct {
+	Name    string                     `json:"name"`
+	EndTime uint64                     `json:"end_time"`
+	Version uint64                     `json:"version"`
+	MaxCost string                     `json:"max_cost"`
+	Args    map[string]json.RawMessage `json:"args"`
+}
+
+type NftOnTransferInput struct {
+	SenderId        string `json:"sender_id"`
+	PreviousOwnerId string `json:"previous_owner_id"`
+	TokenId         string `json:"token_id"`
+	Msg             string `json:"msg"`
+}
+
+type DepositInput struct {
+	NftContract string `json:"nft_contract"`
+}
+
+type GetDepositInput struct {
+	Account     string `json:"account"`
+	NftContract string `json:"nft_contract"`
+}
+
+type WithdrawDepositInput struct {
+	NftContract string `json:"nft_contract"`
+	Amount      string `json:"amount"`
+}
+
+type WithdrawDepositCallbackInput struct {
+	Account     string `json:"account"`
+	NftContract string `json:"nft_contract"`
+	Amount      string `json:"amount"`
+}
+
+type InitInput struct {
+	Council    []string `json:"council"`
+	Threshold  uint64   `json:"threshold"`
+	TimelockMs uint64   `json:"timelock_ms"`
+}
+
+// CouncilInfo is the governance of template releases. Without a council the
+// factory account releases code itself through UpdateAuctionContract.
+type CouncilInfo struct {
+	Council    []string `json:"council"`
+	Threshold  uint64   `json:"threshold"`
+	TimelockMs uint64   `json:"timelock_ms"`
+}
+
+type SweepInput struct {
+	From  uint64 `json:"from"`
+	Limit uint64 `json:"limit"`
+}
+
+type SweepCallbackInput struct {
+	Index     uint64 `json:"index"`
+	ToFactory bool   `json:"to_factory,omitempty"`
+}
+
+type SetReclaimToInput struct {
+	ReclaimTo string `json:"reclaim_to"`
+}
+
+type SetFeesInput struct {
+	DeployFee      string `json:"deploy_fee"`
+	ProtocolFeeBps uint64 `json:"protocol_fee_bps"`
+}
+
+// WithdrawFeesInput pays out NEAR fees or, when Token is given, protocol
+// fees FT auctions paid in that token.
+type WithdrawFeesInput struct {
+	Receiver string `json:"receiver"`
+	Amount   string `json:"amount"`
+	Token    string `json:"token"`
+}
+
+type WithdrawFeesCallbackInput struct {
+	Receiver string `json:"receiver"`
+	Amount   string `json:"amount"`
+}
+
+// FeeInfo is the fee configuration and the NEAR treasury. Balance is what
+// can still be withdrawn. Protocol fees FT auctions pay in their bid token
+// are held on the token contracts and not tracked here.
+type FeeInfo struct {
+	DeployFee             string `json:"deploy_fee"`
+	ProtocolFeeBps        uint64 `json:"protocol_fee_bps"`
+	Balance               string `json:"balance"`
+	DeployFeesCollected   string `json:"deploy_fees_collected"`
+	ProtocolFeesCollected string `json:"protocol_fees_collected"`
+	Reclaimed             string `json:"reclaimed"`
+	Withdrawn             string `json:"withdrawn"`
+}
+
+type UploadCodeInput struct {
 	Code string `json:"code"`
 }
 
+type ProposeInput struct {
+	Kind     string     `json:"kind"`
+	Hash     string     `json:"hash"`
+	Note     string     `json:"note"`
+	Required []string   `json:"required"`
+	Optional []string   `json:"optional"`
+	Variants [][]string `json:"variants"`
+	Methods  []string   `json:"methods"`
+}
+
+type ProposalInput struct {
+	Id uint64 `json:"id"`
+}
+
+// CodeProposal is a council proposal to release uploaded code, or, when
+// Deprecation is set, to deprecate or restore a release. It can be applied
+// TimelockMs after it reaches the approval threshold, and expires if it
+// doesn't reach it within proposalTtlMs. Proposals are dropped from the state
+// once applied, cancelled or expired.
+type CodeProposal struct {
+	Id          uint64          `json:"id"`
+	Kind        string          `json:"kind"`
+	Hash        string          `json:"hash"`
+	Size        uint64          `json:"size"`
+	Note        string          `json:"note"`
+	Required    []string        `json:"required"`
+	Optional    []string        `json:"optional"`
+	Variants    [][]string      `json:"variants"`
+	Methods     []string        `json:"methods"`
+	Deprecation *DeprecateInput `json:"deprecation,omitempty"`
+	Proposer    string          `json:"proposer"`
+	Approvals   []string        `json:"approvals"`
+	ProposedAt  uint64          `json:"proposed_at"`
+	ApprovedAt  uint64          `json:"approved_at"`
+}
+
+func (p *CodeProposal) expired(now uint64) bool {
+	return p.ApprovedAt == 0 && now >= p.ProposedAt+proposalTtlMs
+}
+
+// CodeUpdateEvent is the data of the code_update_* events.
+type CodeUpdateEvent struct {
+	ProposalId   uint64 `json:"proposal_id"`
+	Kind         string `json:"kind"`
+	Hash         string `json:"hash"`
+	Account      string `json:"account,omitempty"`
+	Approvals    uint64 `json:"approvals"`
+	ExecutableAt uint64 `json:"executable_at,omitempty"`
+	Version      uint64 `json:"version,omitempty"`
+}
+
+// ReleaseEvent is the data of the release_deprecated and release_restored
+// events.
+type ReleaseEvent struct {
+	Kind    string `json:"kind"`
+	Version uint64 `json:"version"`
+	Hash    string `json:"hash"`
+}
+
 // @contract:state
 type FactoryContract struct {
-	Code []byte `json:"code"`
+	Council      []string                                 `json:"council"`
+	Threshold    uint64                                   `json:"threshold"`
+	TimelockMs   uint64                                   `json:"timelock_ms"`
+	Proposals    []CodeProposal                           `json:"proposals"`
+	ProposalSeq  uint64                                   `json:"proposal_seq"`
+	Templates    map[string]*Template                     `json:"templates"`
+	Fees         FeeInfo                                  `json:"fees"`
+	ReclaimTo    string                                   `json:"reclaim_to"`
+	Auctions     *collections.Vector[AuctionRecord]       `json:"auctions"`
+	ByAuctioneer *collections.LookupMap[string, []uint64] `json:"by_auctioneer"`
+	ByAccount    *collections.LookupMap[string, uint64]   `json:"by_account"`
+	Deposits     *collections.LookupMap[string, string]   `json:"deposits"`
 }
 
 // @contract:init
-func (c *FactoryContract) Init() {
-	c.Code = embeddedAuctionWasm
+func (c *FactoryContract) Init(input InitInput) {
+	if err := c.setCouncil(input); err != nil {
+		env.PanicStr(err.Error())
+		return
+	}
+	c.seedTemplates()
+	c.Auctions = collections.NewVector[AuctionRecord]("a")
+	c.ByAuctioneer = collections.NewLookupMap[string, []uint64]("b")
+	c.ByAccount = collections.NewLookupMap[string, uint64]("n")
+	c.Deposits = collections.NewLookupMap[string, string]("d")
 	env.LogString("Factory initialized")
 }
 
-// @contract:payable min_deposit=0
-func (c *FactoryContract) DeployNewAuction(input DeployInput) error {
-	currentAccount, err := env.GetCurrentAccountId()
+// registry returns the auction registry, creating it for factories
+// initialized before it existed.
+func (c *FactoryContract) registry() (*collections.Vector[AuctionRecord], *collections.LookupMap[string, []uint64]) {
+	if c.Auctions == nil {
+		c.Auctions = collections.NewVector[AuctionRecord]("a")
+	}
+	if c.ByAuctioneer == nil {
+		c.ByAuctioneer = collections.NewLookupMap[string, []uint64]("b")
+	}
+	return c.Auctions, c.ByAuctioneer
+}
+
+// accounts indexes the registry by auction account. Auctions registered
+// before it existed are not in it.
+func (c *FactoryContract) accounts() *collections.LookupMap[string, uint64] {
+	if c.ByAccount == nil {
+		c.ByAccount = collections.NewLookupMap[string, uint64]("n")
+	}
+	return c.ByAccount
+}
+
+// deposits holds the prepaid deposits of one-call listings, creating them for
+// factories initialized before they existed.
+func (c *FactoryContract) deposits() *collections.LookupMap[string, string] {
+	if c.Deposits == nil {
+		c.Deposits = collections.NewLookupMap[string, string]("d")
+	}
+	return c.Deposits
+}
+
+// depositKey keys the deposit of account for listings of nftContract.
+// Account IDs cannot hold ':', so keys of different pairs never collide.
+func depositKey(account, nftContract string) string {
+	return account + ":" + nftContract
+}
+
+// depositOf returns the prepaid deposit of account for listings of
+// nftContract, zero when it has none.
+func (c *FactoryContract) depositOf(account, nftContract string) types.Uint128 {
+	deposit, err := c.deposits().Get(depositKey(account, nftContract))
 	if err != nil {
-		return errors.New("failed to get current account")
+		return types.Uint128{Hi: 0, Lo: 0}
 	}
+	return amountOf(deposit)
+}
 
-	subaccount := input.Name + "." + currentAccount
-	if len(subaccount) < 2 || len(subaccount) > 64 {
-		return errors.New("invalid subaccount name")
+// setDeposit stores the prepaid deposit of account for listings of
+// nftContract, dropping empty ones.
+func (c *FactoryContract) setDeposit(account, nftContract string, amount types.Uint128) error {
+	key := depositKey(account, nftContract)
+	if amount.Cmp(types.Uint128{Hi: 0, Lo: 0}) == 0 {
+		return c.deposits().Remove(key)
 	}
+	return c.deposits().Insert(key, amount.String())
+}
 
-	attached, err := env.GetAttachedDeposit()
+// credit adds amount to the prepaid deposit of account for listings of
+// nftContract.
+func (c *FactoryContract) credit(account, nftContract string, amount types.Uint128) error {
+	deposit, err := c.depositOf(account, nftContract).Add(amount)
 	if err != nil {
-		return errors.New("failed to get attached deposit")
+		return errors.New("deposit overflow")
+	}
+	return c.setDeposit(account, nftContract, deposit)
+}
+
+// validateName checks that name is a single account ID label: lowercase
+// letters and digits, separated by single '-' or '_'.
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("subaccount name is empty")
+	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		case ch == '-' || ch == '_':
+			if i == 0 || i == len(name)-1 || name[i-1] == '-' || name[i-1] == '_' {
+				return errors.New("subaccount name can't start, end or repeat a separator")
+			}
+		default:
+			return errors.New("subaccount name can only hold lowercase letters, digits, '-' and '_'")
+		}
+	}
+	return nil
+}
+
+// seedTemplates releases the templates embedded in the factory as version 1.
+func (c *FactoryContract) seedTemplates() {
+	c.Templates = map[string]*Template{
+		KindBasic: {
+			Required: []string{"end_time", "auctioneer"},
+			Optional: []string{"protocol_fee"},
+			Methods:  []string{"bid", "claim", "self_destruct"},
+		},
+		KindNft: {
+			Required: []string{"end_time", "auctioneer"},
+			Optional: []string{"nft_contract", "token_id", "bundle", "return_address", "protocol_fee", "defer_verification"},
+			Variants: [][]string{{"nft_contract", "token_id"}, {"bundle"}},
+			Methods:  []string{"bid", "claim", "self_destruct", "verify_token"},
+		},
+		KindFt: {
+			Required: []string{"end_time", "auctioneer", "nft_contract", "token_id", "starting_price"},
+			Optional: []string{
+				"ft_contract", "max_price", "return_address", "storage_deposit",
+				"accepted_tokens", "wrap_contract", "payout_form", "protocol_fee",
+			},
+			Methods: []string{"ft_on_transfer", "claim", "self_destruct"},
+		},
+	}
+	for _, kind := range []string{KindBasic, KindNft, KindFt} {
+		if _, err := storeRelease(c.Templates[kind], embeddedCode(kind), "embedded"); err != nil {
+			env.PanicStr("failed to store the " + kind + " template")
+		}
+	}
+}
+
+func embeddedCode(kind string) []byte {
+	switch kind {
+	case KindBasic:
+		return embeddedBasicWasm
+	case KindNft:
+		return embeddedNftWasm
+	default:
+		return embeddedFtWasm
+	}
+}
+
+// storeRelease stores code under its hash and adds it to t as the next
+// version.
+func storeRelease(t *Template, code []byte, note string) (CodeRelease, error) {
+	hash, err := storeCode(code)
+	if err != nil {
+		return CodeRelease{}, err
+	}
+	return t.addRelease(hash, uint64(len(code)), note), nil
+}
+
+func storeCode(code []byte) (string, error) {
+	hash := codeHash(code)
+	if _, err := env.StorageWrite([]byte(codePrefix+hash), code); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func (t *Template) addRelease(hash string, size uint64, note string) CodeRelease {
+	release := CodeRelease{
+		Version: uint64(len(t.Releases)) + 1,
+		Hash:    hash,
+		Size:    size,
+		Note:    note,
+	}
+	t.Releases = append(t.Releases, release)
+	return release
+}
+
+func loadCode(hash string) ([]byte, error) {
+	code, err := env.StorageRead([]byte(codePrefix + hash))
+	if err != nil || len(code) == 0 {
+		return nil, errors.New("code " + hash + " is missing")
+	}
+	return code, nil
+}
+
+func codeHash(code []byte) string {
+	sum := sha256.Sum256(code)
+	return base58.Encode(sum[:])
+}
+
+// template returns the template of kind, seeding the embedded templates for
+// factories initialized before there was more than one.
+func (c *FactoryContract) template(kind string) (string, *Template, error) {
+	if c.Templates == nil {
+		c.seedTemplates()
+	}
+	if kind == "" {
+		kind = defaultKind
 	}
+	template, ok := c.Templates[kind]
+	if !ok {
+		return kind, nil, errors.New("unknown auction kind " + kind)
+	}
+	return kind, template, nil
+}
+
+// pick returns the given version of t, or its latest release that isn't
+// deprecated when version is 0.
+func (t *Template) pick(kind string, version uint64) (CodeRelease, error) {
+	if version == 0 {
+		for i := len(t.Releases) - 1; i >= 0; i-- {
+			if !t.Releases[i].Deprecated {
+				return t.Releases[i], nil
+			}
+		}
+		return CodeRelease{}, errors.New("no release of the " + kind + " template is available")
+	}
+
+	if version > uint64(len(t.Releases)) {
+		return CodeRelease{}, errors.New("unknown version " + types.IntToString(int(version)) + " of the " + kind + " template")
+	}
+	release := t.Releases[version-1]
+	if release.Deprecated {
+		return CodeRelease{}, errors.New("version " + types.IntToString(int(version)) + " of the " + kind + " template is deprecated")
+	}
+	return release, nil
+}
+
+// initArgs builds the init arguments of a deployment and checks them against
+// the schema of its template.
+func (t *Template) initArgs(kind string, input DeployInput) (map[string]json.RawMessage, error) {
+	args := map[string]json.RawMessage{}
+	if input.EndTime != 0 {
+		encoded, err := json.Marshal(input.EndTime)
+		if err != nil {
+			return nil, errors.New("invalid argument end_time")
+		}
+		args["end_time"] = encoded
+	}
+	named := map[string]string{
+		"auctioneer":     input.Auctioneer,
+		"ft_contract":    input.FtContract,
+		"nft_contract":   input.NftContract,
+		"token_id":       input.TokenId,
+		"starting_price": input.StartingPrice,
+	}
+	for name, value := range named {
+		if value == "" {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.New("invalid argument " + name)
+		}
+		args[name] = encoded
+	}
+	for name, value := range input.Args {
+		if name == "protocol_fee" || name == "defer_verification" {
+			return nil, errors.New("argument " + name + " is set by the factory")
+		}
+		if _, ok := args[name]; ok {
+			return nil, errors.New("argument " + name + " is given twice")
+		}
+		args[name] = value
+	}
+
+	for name := range args {
+		if !contains(t.Required, name) && !contains(t.Optional, name) {
+			return nil, errors.New("argument " + name + " is not accepted by the " + kind + " template")
+		}
+	}
+	for _, name := range t.Required {
+		if _, ok := args[name]; !ok {
+			return nil, errors.New("missing argument " + name + " for the " + kind + " template")
+		}
+	}
+	if err := t.checkVariants(kind, args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// checkVariants checks that args hold exactly one of the template's
+// variants, and all of it.
+func (t *Template) checkVariants(kind string, args map[string]json.RawMessage) error {
+	if len(t.Variants) == 0 {
+		return nil
+	}
+
+	given := 0
+	for _, variant := range t.Variants {
+		found := 0
+		for _, name := range variant {
+			if _, ok := args[name]; ok {
+				found++
+			}
+		}
+		if found == 0 {
+			continue
+		}
+		if found < len(variant) {
+			return errors.New("the " + kind + " template needs " + strings.Join(variant, ", ") + " together")
+		}
+		given++
+	}
+	if given != 1 {
+		alternatives := make([]string, 0, len(t.Variants))
+		for _, variant := range t.Variants {
+			alternatives = append(alternatives, strings.Join(variant, ", "))
+		}
+		return errors.New("the " + kind + " template needs exactly one of: " + strings.Join(alternatives, " | "))
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
 
-	storageCost, err := types.U64ToUint128(nearPerStorageByte).SafeMul64(uint64(len(c.Code)))
+// DeployNewAuction creates the auction account Name under the factory and
+// deploys and initializes a release of the template there. The deposit has to
+// cover GetDeployCost, any surplus is refunded once the auction is
+// registered. Besides its own work the call reserves 40 TGas for the
+// auction's init and 60 TGas for the registration, so 300 TGas covers it.
+//
+// @contract:payable min_deposit=0
+func (c *FactoryContract) DeployNewAuction(input DeployInput) error {
+	d, err := c.prepare(input)
 	if err != nil {
-		return errors.New("storage cost overflow")
+		return err
 	}
-	extraDeposit, _ := types.U128FromString("100000000000000000000000")
-	minimum, err := storageCost.Add(extraDeposit)
+	minimum, err := d.storage.Add(d.fee)
 	if err != nil {
 		return errors.New("minimum deposit overflow")
 	}
 
+	attached, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
 	if attached.Cmp(minimum) < 0 {
 		return errors.New("insufficient deposit to deploy auction")
 	}
+	surplus, err := attached.Sub(minimum)
+	if err != nil {
+		return errors.New("failed to compute the surplus deposit")
+	}
 
 	caller, err := env.GetPredecessorAccountID()
 	if err != nil {
 		return errors.New("failed to get caller")
 	}
 
-	initArgs := AuctionInitArgs{
-		EndTime:       input.EndTime,
-		Auctioneer:    input.Auctioneer,
-		FtContract:    input.FtContract,
-		NftContract:   input.NftContract,
-		TokenId:       input.TokenId,
-		StartingPrice: input.StartingPrice,
+	callbackArgs := d.callbackArgs(input, caller)
+	callbackArgs.Attached = attached.String()
+	callbackArgs.Surplus = surplus.String()
+	return d.send("deploy_new_auction_callback", callbackArgs, registerGas)
+}
+
+// deployment is a DeployInput checked against its template, with the
+// release to deploy and what deploying it costs.
+type deployment struct {
+	account  string
+	kind     string
+	release  CodeRelease
+	initArgs map[string]json.RawMessage
+	storage  types.Uint128
+	fee      types.Uint128
+}
+
+// prepare checks a deployment: the name, the template and its arguments.
+func (c *FactoryContract) prepare(input DeployInput) (deployment, error) {
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return deployment{}, errors.New("failed to get current account")
+	}
+
+	if err := validateName(input.Name); err != nil {
+		return deployment{}, err
+	}
+	subaccount := input.Name + "." + currentAccount
+	if len(subaccount) < 2 || len(subaccount) > 64 {
+		return deployment{}, errors.New("invalid subaccount name")
+	}
+	if _, err := c.accounts().Get(subaccount); err == nil {
+		return deployment{}, errors.New("auction " + subaccount + " already exists")
+	}
+
+	kind, template, err := c.template(input.Kind)
+	if err != nil {
+		return deployment{}, err
+	}
+	initArgs, err := template.initArgs(kind, input)
+	if err != nil {
+		return deployment{}, err
+	}
+	release, err := template.pick(kind, input.Version)
+	if err != nil {
+		return deployment{}, err
+	}
+	storage, fee, err := c.deployCost(release)
+	if err != nil {
+		return deployment{}, err
+	}
+
+	if c.Fees.ProtocolFeeBps > 0 && contains(template.Optional, "protocol_fee") {
+		protocolFee, err := json.Marshal(core.ProtocolFee{Account: currentAccount, Bps: c.Fees.ProtocolFeeBps})
+		if err != nil {
+			return deployment{}, errors.New("failed to encode the protocol fee")
+		}
+		initArgs["protocol_fee"] = protocolFee
+	}
+
+	return deployment{
+		account:  subaccount,
+		kind:     kind,
+		release:  release,
+		initArgs: initArgs,
+		storage:  storage,
+		fee:      fee,
+	}, nil
+}
+
+func (d deployment) callbackArgs(input DeployInput, user string) DeployCallbackInput {
+	return DeployCallbackInput{
+		Account:     d.account,
+		User:        user,
+		Fee:         d.fee.String(),
+		Kind:        d.kind,
+		Auctioneer:  input.Auctioneer,
+		NftContract: input.NftContract,
+		FtContract:  input.FtContract,
+		TokenId:     input.TokenId,
+		EndTime:     input.EndTime,
+		CodeVersion: d.release.Version,
+		CodeHash:    d.release.Hash,
+	}
+}
+
+// send creates the auction account, deploys and initializes the auction and
+// calls callback on the factory with the outcome, which becomes the result
+// of the current call.
+func (d deployment) send(callback string, callbackArgs interface{}, callbackGas uint64) error {
+	currentAccount, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
 	}
 
-	callbackArgs := DeployCallbackInput{
-		Account:  subaccount,
-		User:     caller,
-		Attached: attached.String(),
+	var code, codeHash []byte
+	if d.release.Published {
+		codeHash, err = base58.Decode(d.release.Hash)
+	} else {
+		code, err = loadCode(d.release.Hash)
+	}
+	if err != nil {
+		return err
 	}
 
 	zero := types.Uint128{Hi: 0, Lo: 0}
-	gas5T := uint64(types.ONE_TERA_GAS * 5)
+	gas40T := uint64(types.ONE_TERA_GAS * 40)
+
+	initBytes, err := json.Marshal(d.initArgs)
+	if err != nil {
+		return errors.New("failed to encode init arguments")
+	}
+	callbackBytes, err := json.Marshal(callbackArgs)
+	if err != nil {
+		return errors.New("failed to encode callback arguments")
+	}
+
+	// The SDK's PromiseBatch can't take the global contract action, so the
+	// batch is built from the env primitives.
+	batch := env.PromiseBatchCreate([]byte(d.account))
+	env.PromiseBatchActionCreateAccount(batch)
+	env.PromiseBatchActionTransfer(batch, d.storage)
+	if d.release.Published {
+		useGlobalContract(batch, codeHash)
+	} else {
+		env.PromiseBatchActionDeployContract(batch, code)
+	}
+	env.PromiseBatchActionFunctionCall(batch, []byte("init"), initBytes, zero, gas40T)
 
-	promise.CreateBatch(subaccount).
-		CreateAccount().
-		Transfer(attached).
-		DeployContract(c.Code).
-		FunctionCall("init", initArgs, zero, gas5T).
-		Then(currentAccount).
-		FunctionCall("deploy_new_auction_callback", callbackArgs, zero, gas5T).
-		Value()
+	then := env.PromiseBatchThen(batch, []byte(currentAccount))
+	env.PromiseBatchActionFunctionCall(then, []byte(callback), callbackBytes, zero, callbackGas)
+	env.PromiseReturn(then)
 
 	return nil
 }
 
+// GetDeployCost returns the deposit DeployNewAuction needs for a version of
+// a template, its latest available release when Version is 0.
+//
 // @contract:view
+func (c *FactoryContract) GetDeployCost(input TemplateInput) (string, error) {
+	kind, template, err := c.template(input.Kind)
+	if err != nil {
+		return "", err
+	}
+	release, err := template.pick(kind, input.Version)
+	if err != nil {
+		return "", err
+	}
+	storage, fee, err := c.deployCost(release)
+	if err != nil {
+		return "", err
+	}
+	cost, err := storage.Add(fee)
+	if err != nil {
+		return "", errors.New("minimum deposit overflow")
+	}
+	return cost.String(), nil
+}
+
+// deployCost returns the storage a deployment forwards to the new account and
+// the deploy fee the factory keeps. Auctions running a published release only
+// pay for their account and state; the others also pay for a copy of the
+// code.
+func (c *FactoryContract) deployCost(release CodeRelease) (types.Uint128, types.Uint128, error) {
+	bytes := accountStorageBytes
+	if !release.Published {
+		bytes += release.Size
+	}
+	storage, err := types.U64ToUint128(nearPerStorageByte).SafeMul64(bytes)
+	if err != nil {
+		return types.Uint128{}, types.Uint128{}, errors.New("storage cost overflow")
+	}
+	return storage, amountOf(c.Fees.DeployFee), nil
+}
+
+// amountOf parses a yoctoNEAR amount of the fee ledger, where "" is zero.
+func amountOf(amount string) types.Uint128 {
+	value, err := types.U128FromString(amount)
+	if err != nil {
+		return types.Uint128{Hi: 0, Lo: 0}
+	}
+	return value
+}
+
+// addFee adds amount to the fee balance and to the total at collected.
+func (c *FactoryContract) addFee(collected *string, amount types.Uint128) {
+	balance, err := amountOf(c.Fees.Balance).Add(amount)
+	if err != nil {
+		env.LogString("Fee balance overflow")
+		return
+	}
+	total, err := amountOf(*collected).Add(amount)
+	if err != nil {
+		env.LogString("Fee total overflow")
+		return
+	}
+	c.Fees.Balance = balance.String()
+	*collected = total.String()
+}
+
+// DeployNewAuctionCallback records a successful deployment in the registry
+// and refunds the surplus deposit, or the whole deposit of a failed one.
+//
+// @contract:mutating
 // @contract:promise_callback
 func (c *FactoryContract) DeployNewAuctionCallback(input DeployCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
 	if result.Success {
 		env.LogString("Correctly created and deployed to " + input.Account)
+		if err := c.register(input); err != nil {
+			env.LogString("Failed to register " + input.Account + ": " + err.Error())
+		}
+		c.addFee(&c.Fees.DeployFeesCollected, amountOf(input.Fee))
+
+		surplus, err := types.U128FromString(input.Surplus)
+		if err == nil && surplus.Cmp(types.Uint128{Hi: 0, Lo: 0}) > 0 {
+			env.LogString("Returning the surplus " + input.Surplus + " to " + input.User)
+			promise.CreateBatch(input.User).Transfer(surplus)
+		}
 		return true
 	}
 
@@ -142,30 +963,1165 @@ func (c *FactoryContract) DeployNewAuctionCallback(input DeployCallbackInput, re
 	return false
 }
 
-// @contract:mutating
-func (c *FactoryContract) UpdateAuctionContract(input UpdateCodeInput) error {
-	caller, err := env.GetPredecessorAccountID()
-	if err != nil {
-		return errors.New("failed to get caller")
-	}
-	current, err := env.GetCurrentAccountId()
+func (c *FactoryContract) register(input DeployCallbackInput) error {
+	auctions, byAuctioneer := c.registry()
+
+	index := auctions.Length()
+	err := auctions.Push(AuctionRecord{
+		Account:     input.Account,
+		Kind:        input.Kind,
+		Auctioneer:  input.Auctioneer,
+		Creator:     input.User,
+		NftContract: input.NftContract,
+		FtContract:  input.FtContract,
+		TokenId:     input.TokenId,
+		EndTime:     input.EndTime,
+		CodeVersion: input.CodeVersion,
+		CodeHash:    input.CodeHash,
+	})
 	if err != nil {
-		return errors.New("failed to get current account")
+		return err
 	}
-	if caller != current {
-		return errors.New("only the contract itself can call this method")
+	if err := c.accounts().Insert(input.Account, index); err != nil {
+		return err
 	}
 
-	code, err := base64.StdEncoding.DecodeString(input.Code)
+	indexes, err := byAuctioneer.Get(input.Auctioneer)
 	if err != nil {
-		return errors.New("invalid base64 code")
+		indexes = nil
 	}
-
-	c.Code = code
-	return nil
+	return byAuctioneer.Insert(input.Auctioneer, append(indexes, index))
 }
 
-// @contract:view
-func (c *FactoryContract) GetCodeSize() int {
-	return len(c.Code)
+// Deposit prepays one-call listings of the caller, see NftOnTransfer. The
+// deposit only pays for tokens of NftContract, so that no other contract can
+// spend it by naming the caller as the sender of a transfer.
+//
+// @contract:payable min_deposit=0
+func (c *FactoryContract) Deposit(input DepositInput) error {
+	attached, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
+	if attached.Cmp(types.Uint128{Hi: 0, Lo: 0}) == 0 {
+		return errors.New("attach a deposit")
+	}
+	if input.NftContract == "" {
+		return errors.New("missing nft_contract")
+	}
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller")
+	}
+
+	if err := c.credit(caller, input.NftContract, attached); err != nil {
+		return err
+	}
+	env.LogString("Deposited " + attached.String() + " for listings of " + caller + " on " + input.NftContract)
+	return nil
+}
+
+// WithdrawDeposit pays back the caller's prepaid deposit for NftContract,
+// all of it when Amount is empty.
+//
+// @contract:mutating
+func (c *FactoryContract) WithdrawDeposit(input WithdrawDepositInput) error {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller")
+	}
+
+	deposit := c.depositOf(caller, input.NftContract)
+	amount := deposit
+	if input.Amount != "" {
+		amount, err = types.U128FromString(input.Amount)
+		if err != nil {
+			return errors.New("invalid amount")
+		}
+	}
+	if amount.Cmp(types.Uint128{Hi: 0, Lo: 0}) <= 0 {
+		return errors.New("nothing to withdraw")
+	}
+	rest, err := deposit.Sub(amount)
+	if err != nil || deposit.Cmp(amount) < 0 {
+		return errors.New("amount exceeds the deposit")
+	}
+	if err := c.setDeposit(caller, input.NftContract, rest); err != nil {
+		return errors.New("failed to update the deposit")
+	}
+
+	current, _ := env.GetCurrentAccountId()
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+
+	promise.CreateBatch(caller).
+		Transfer(amount).
+		Then(current).
+		FunctionCall("withdraw_deposit_callback", WithdrawDepositCallbackInput{Account: caller, NftContract: input.NftContract, Amount: amount.String()}, zero, gas5T)
+
+	return nil
+}
+
+// WithdrawDepositCallback puts a withdrawal that could not be paid back into
+// the deposit.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FactoryContract) WithdrawDepositCallback(input WithdrawDepositCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if result.Success {
+		env.LogString("Withdrew a deposit of " + input.Amount + " to " + input.Account)
+		return true
+	}
+
+	if err := c.credit(input.Account, input.NftContract, amountOf(input.Amount)); err != nil {
+		env.LogString("Failed to restore the deposit of " + input.Account + ": " + err.Error())
+		return false
+	}
+	env.LogString("Failed to withdraw " + input.Amount + " to " + input.Account + ", restored the deposit")
+	return false
+}
+
+// NftOnTransfer lists a token sent with nft_transfer_call in a new NFT
+// auction, so that a seller who has made a Deposit lists with a single
+// transaction. Msg is a ListingMsg and the previous owner becomes the
+// auctioneer. Only the sender's deposit for the calling contract is charged,
+// so a contract posing as another one cannot spend it. Like DeployNewAuction
+// the call reserves 40 TGas for the auction's init and 60 TGas for the
+// registration, which also forwards the token, so 300 TGas covers it.
+//
+// Its result is that of NftListingCallback: false once the auction is
+// deployed and the token on its way there, true when the deployment failed
+// and the NFT contract has to give the token back. An invalid listing fails
+// the call, which gives the token back as well.
+//
+// @contract:mutating
+func (c *FactoryContract) NftOnTransfer(input NftOnTransferInput) error {
+	nft, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller account")
+	}
+
+	var msg ListingMsg
+	if err := json.Unmarshal([]byte(input.Msg), &msg); err != nil {
+		return errors.New("invalid listing msg")
+	}
+	deploy := DeployInput{
+		Name:        msg.Name,
+		Kind:        KindNft,
+		EndTime:     msg.EndTime,
+		Auctioneer:  input.PreviousOwnerId,
+		NftContract: nft,
+		TokenId:     input.TokenId,
+		Version:     msg.Version,
+		Args:        msg.Args,
+	}
+	d, err := c.prepareListing(deploy)
+	if err != nil {
+		return err
+	}
+
+	cost, err := d.storage.Add(d.fee)
+	if err != nil {
+		return errors.New("minimum deposit overflow")
+	}
+	if msg.MaxCost != "" {
+		maxCost, err := types.U128FromString(msg.MaxCost)
+		if err != nil {
+			return errors.New("invalid max_cost")
+		}
+		if cost.Cmp(maxCost) > 0 {
+			return errors.New("listing costs " + cost.String() + ", more than max_cost")
+		}
+	}
+	deposit := c.depositOf(input.SenderId, nft)
+	if deposit.Cmp(cost) < 0 {
+		return errors.New("listing costs " + cost.String() + ", more than the deposit of " + input.SenderId + " for " + nft)
+	}
+	rest, err := deposit.Sub(cost)
+	if err != nil {
+		return errors.New("failed to charge the deposit")
+	}
+
+	callbackArgs := d.callbackArgs(deploy, input.SenderId)
+	callbackArgs.Attached = cost.String()
+	callbackArgs.Surplus = "0"
+	if err := d.send("nft_listing_callback", callbackArgs, registerGas); err != nil {
+		return err
+	}
+	if err := c.setDeposit(input.SenderId, nft, rest); err != nil {
+		return errors.New("failed to charge the deposit")
+	}
+	return nil
+}
+
+// prepareListing checks the deployment of a listing. The token only reaches
+// the auction after its init, so templates that can defer the verification
+// leave it to NftListingCallback.
+func (c *FactoryContract) prepareListing(input DeployInput) (deployment, error) {
+	d, err := c.prepare(input)
+	if err != nil {
+		return deployment{}, err
+	}
+	if _, template, err := c.template(d.kind); err == nil && contains(template.Optional, "defer_verification") {
+		d.initArgs["defer_verification"] = json.RawMessage("true")
+	}
+	return d, nil
+}
+
+// NftListingCallback registers an auction deployed by NftOnTransfer and
+// forwards the token to it, then has it verify the token, a check the
+// auction deferred since the factory still held the token at its init. A
+// failed deployment is credited back to the seller's deposit and returns
+// true, which sends the token back.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FactoryContract) NftListingCallback(input DeployCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if !result.Success {
+		env.LogString("Error creating " + input.Account + ", returning token " + input.TokenId + " and crediting " + input.Attached + " to " + input.User)
+		if err := c.credit(input.User, input.NftContract, amountOf(input.Attached)); err != nil {
+			env.LogString("Failed to credit the deposit of " + input.User + ": " + err.Error())
+		}
+		return true
+	}
+
+	env.LogString("Correctly created and deployed to " + input.Account)
+	if err := c.register(input); err != nil {
+		env.LogString("Failed to register " + input.Account + ": " + err.Error())
+	}
+	c.addFee(&c.Fees.DeployFeesCollected, amountOf(input.Fee))
+
+	// nft_transfer takes exactly one yoctoNEAR, which the factory pays.
+	oneYocto := types.Uint128{Hi: 0, Lo: 1}
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+	gas30T := uint64(types.ONE_TERA_GAS * 30)
+
+	env.LogString("Forwarding token " + input.TokenId + " to " + input.Account)
+	promise.CreateBatch(input.NftContract).
+		FunctionCall("nft_transfer", core.NftTransferArgs{ReceiverId: input.Account, TokenId: input.TokenId}, oneYocto, gas10T).
+		Then(input.Account).
+		FunctionCall("verify_token", struct{}{}, zero, gas30T)
+	return false
+}
+
+// @contract:view
+func (c *FactoryContract) GetDeposit(input GetDepositInput) string {
+	return c.depositOf(input.Account, input.NftContract).String()
+}
+
+// UpdateAuctionContract releases new code for a template. Earlier versions
+// stay deployable until they are deprecated.
+//
+// @contract:mutating
+func (c *FactoryContract) UpdateAuctionContract(input UpdateCodeInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+	if len(c.Council) > 0 {
+		return errors.New("code updates go through council proposals")
+	}
+
+	code, err := base64.StdEncoding.DecodeString(input.Code)
+	if err != nil {
+		return errors.New("invalid base64 code")
+	}
+	schema := Template{Required: input.Required, Optional: input.Optional, Variants: input.Variants, Methods: input.Methods}
+	if err := validateWasm(code, c.methods(input.Kind, schema)); err != nil {
+		return err
+	}
+	hash, err := storeCode(code)
+	if err != nil {
+		return errors.New("failed to store code")
+	}
+
+	_, err = c.releaseCode(input.Kind, hash, uint64(len(code)), input.Note, schema)
+	return err
+}
+
+// methods returns the methods code released for kind must export: those of
+// schema when it replaces the template's arguments, else the template's.
+func (c *FactoryContract) methods(kind string, schema Template) []string {
+	if len(schema.Required) > 0 {
+		return schema.Methods
+	}
+	if _, template, err := c.template(kind); err == nil {
+		return template.Methods
+	}
+	return nil
+}
+
+// releaseCode adds stored code as the next release of a template, creating
+// the template if kind is new. A schema with required arguments replaces the
+// template's arguments and methods.
+func (c *FactoryContract) releaseCode(kind, hash string, size uint64, note string, schema Template) (CodeRelease, error) {
+	kind, template, err := c.template(kind)
+	if err != nil {
+		if len(schema.Required) == 0 {
+			return CodeRelease{}, errors.New("a new template needs its required arguments")
+		}
+		template = &Template{}
+		c.Templates[kind] = template
+	}
+	if len(schema.Required) > 0 {
+		template.Required = schema.Required
+		template.Optional = schema.Optional
+		template.Variants = schema.Variants
+		template.Methods = schema.Methods
+	}
+
+	release := template.addRelease(hash, size, note)
+	env.LogString("Released version " + types.IntToString(int(release.Version)) + " of the " + kind + " template: " + release.Hash)
+	return release, nil
+}
+
+// PublishRelease publishes a release as a global contract, paid from the
+// factory's balance. Later deployments of it reference the code hash instead
+// of carrying the code.
+//
+// @contract:mutating
+func (c *FactoryContract) PublishRelease(input TemplateInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+
+	kind, template, err := c.template(input.Kind)
+	if err != nil {
+		return err
+	}
+	release, err := template.pick(kind, input.Version)
+	if err != nil {
+		return err
+	}
+	if release.Published {
+		return errors.New("version " + types.IntToString(int(release.Version)) + " of the " + kind + " template is already published")
+	}
+	code, err := loadCode(release.Hash)
+	if err != nil {
+		return err
+	}
+
+	current, _ := env.GetCurrentAccountId()
+	callbackBytes, err := json.Marshal(PublishCallbackInput{Kind: kind, Version: release.Version})
+	if err != nil {
+		return errors.New("failed to encode callback arguments")
+	}
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+
+	batch := env.PromiseBatchCreate([]byte(current))
+	deployGlobalContract(batch, code)
+	then := env.PromiseBatchThen(batch, []byte(current))
+	env.PromiseBatchActionFunctionCall(then, []byte("publish_callback"), callbackBytes, zero, gas5T)
+	env.PromiseReturn(then)
+
+	return nil
+}
+
+// PublishCallback marks a release as published once its global contract is
+// deployed.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FactoryContract) PublishCallback(input PublishCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	version := types.IntToString(int(input.Version))
+	if !result.Success {
+		env.LogString("Failed to publish version " + version + " of the " + input.Kind + " template")
+		return false
+	}
+
+	_, template, err := c.template(input.Kind)
+	if err != nil || input.Version == 0 || input.Version > uint64(len(template.Releases)) {
+		env.LogString("Published an unknown release " + version + " of the " + input.Kind + " template")
+		return false
+	}
+	template.Releases[input.Version-1].Published = true
+	env.LogString("Published version " + version + " of the " + input.Kind + " template as " + template.Releases[input.Version-1].Hash)
+	return true
+}
+
+// DeprecateRelease withdraws a version of a template from deployment, or
+// brings it back. Deprecating the latest version rolls deployments without a
+// pinned version back to the previous one. Once a council is configured
+// this goes through ProposeDeprecation instead.
+//
+// @contract:mutating
+func (c *FactoryContract) DeprecateRelease(input DeprecateInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+	if len(c.Council) > 0 {
+		return errors.New("deprecations go through council proposals")
+	}
+	return c.deprecate(input)
+}
+
+// deprecate sets the Deprecated flag of a release.
+func (c *FactoryContract) deprecate(input DeprecateInput) error {
+	kind, release, err := c.releaseAt(input.Kind, input.Version)
+	if err != nil {
+		return err
+	}
+
+	release.Deprecated = input.Deprecated
+	event := "release_restored"
+	if input.Deprecated {
+		event = "release_deprecated"
+	}
+	env.LogString(core.NewEvent(event, ReleaseEvent{Kind: kind, Version: release.Version, Hash: release.Hash}).String())
+	return nil
+}
+
+func (c *FactoryContract) releaseAt(kind string, version uint64) (string, *CodeRelease, error) {
+	kind, template, err := c.template(kind)
+	if err != nil {
+		return "", nil, err
+	}
+	if version == 0 || version > uint64(len(template.Releases)) {
+		return "", nil, errors.New("unknown version " + types.IntToString(int(version)) + " of the " + kind + " template")
+	}
+	return kind, &template.Releases[version-1], nil
+}
+
+// SetCouncil hands template releases over to a council. It is meant for
+// factories initialized without one; the council can't be replaced later.
+//
+// @contract:mutating
+func (c *FactoryContract) SetCouncil(input InitInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+	if len(c.Council) > 0 {
+		return errors.New("the council is already configured")
+	}
+	return c.setCouncil(input)
+}
+
+func (c *FactoryContract) setCouncil(input InitInput) error {
+	council := []string{}
+	for _, member := range input.Council {
+		if member == "" {
+			return errors.New("invalid council member")
+		}
+		if contains(council, member) {
+			return errors.New("council member " + member + " is listed twice")
+		}
+		council = append(council, member)
+	}
+	if len(council) > 0 && (input.Threshold == 0 || input.Threshold > uint64(len(council))) {
+		return errors.New("threshold must be between 1 and the council size")
+	}
+
+	c.Council = council
+	c.Threshold = input.Threshold
+	c.TimelockMs = input.TimelockMs
+	return nil
+}
+
+// UploadCode stores code for a proposal to reference by hash. The deposit
+// pays for its storage.
+//
+// @contract:payable min_deposit=0
+func (c *FactoryContract) UploadCode(input UploadCodeInput) (string, error) {
+	code, err := base64.StdEncoding.DecodeString(input.Code)
+	if err != nil {
+		return "", errors.New("invalid base64 code")
+	}
+	if err := validateWasm(code, nil); err != nil {
+		return "", err
+	}
+
+	hash := codeHash(code)
+	if _, err := loadCode(hash); err == nil {
+		return hash, nil
+	}
+
+	attached, err := env.GetAttachedDeposit()
+	if err != nil {
+		return "", errors.New("failed to get attached deposit")
+	}
+	cost, err := types.U64ToUint128(nearPerStorageByte).SafeMul64(uint64(len(code)))
+	if err != nil {
+		return "", errors.New("storage cost overflow")
+	}
+	if attached.Cmp(cost) < 0 {
+		return "", errors.New("insufficient deposit to store code")
+	}
+
+	if _, err := storeCode(code); err != nil {
+		return "", errors.New("failed to store code")
+	}
+	env.LogString("Uploaded code " + hash)
+	return hash, nil
+}
+
+// ProposeCodeUpdate proposes releasing uploaded code as the next version of
+// a template. The proposer's approval is counted.
+//
+// @contract:mutating
+func (c *FactoryContract) ProposeCodeUpdate(input ProposeInput) (uint64, error) {
+	caller, err := c.requireCouncil()
+	if err != nil {
+		return 0, err
+	}
+
+	code, err := loadCode(input.Hash)
+	if err != nil {
+		return 0, errors.New("code " + input.Hash + " has not been uploaded")
+	}
+	kind, _, err := c.template(input.Kind)
+	if err != nil && len(input.Required) == 0 {
+		return 0, errors.New("a new template needs its required arguments")
+	}
+	schema := Template{Required: input.Required, Optional: input.Optional, Variants: input.Variants, Methods: input.Methods}
+	if err := validateWasm(code, c.methods(input.Kind, schema)); err != nil {
+		return 0, err
+	}
+
+	return c.addProposal(CodeProposal{
+		Kind:     kind,
+		Hash:     input.Hash,
+		Size:     uint64(len(code)),
+		Note:     input.Note,
+		Required: input.Required,
+		Optional: input.Optional,
+		Variants: input.Variants,
+		Methods:  input.Methods,
+		Proposer: caller,
+	}), nil
+}
+
+// ProposeDeprecation proposes deprecating or restoring a release, see
+// DeprecateRelease. The proposer's approval is counted.
+//
+// @contract:mutating
+func (c *FactoryContract) ProposeDeprecation(input DeprecateInput) (uint64, error) {
+	caller, err := c.requireCouncil()
+	if err != nil {
+		return 0, err
+	}
+
+	kind, release, err := c.releaseAt(input.Kind, input.Version)
+	if err != nil {
+		return 0, err
+	}
+	deprecation := input
+	deprecation.Kind = kind
+
+	return c.addProposal(CodeProposal{
+		Kind:        kind,
+		Hash:        release.Hash,
+		Size:        release.Size,
+		Note:        release.Note,
+		Deprecation: &deprecation,
+		Proposer:    caller,
+	}), nil
+}
+
+// @contract:mutating
+func (c *FactoryContract) ApproveCodeUpdate(input ProposalInput) error {
+	caller, err := c.requireCouncil()
+	if err != nil {
+		return err
+	}
+
+	proposal, err := c.openProposal(input.Id)
+	if err != nil {
+		return err
+	}
+	if contains(proposal.Approvals, caller) {
+		return errors.New("already approved")
+	}
+
+	c.approve(proposal, caller)
+	return nil
+}
+
+// CancelProposal withdraws a proposal that has not been applied and drops
+// it. Only its proposer can cancel it.
+//
+// @contract:mutating
+func (c *FactoryContract) CancelProposal(input ProposalInput) error {
+	caller, err := c.requireCouncil()
+	if err != nil {
+		return err
+	}
+
+	proposal, err := c.openProposal(input.Id)
+	if err != nil {
+		return err
+	}
+	if proposal.Proposer != caller {
+		return errors.New("only the proposer can cancel the proposal")
+	}
+
+	c.emitProposal("code_update_cancelled", proposal, caller)
+	c.dropProposal(proposal.Id)
+	return nil
+}
+
+// ApplyCodeUpdate carries out an approved proposal once its timelock has
+// passed. Anyone can apply it.
+//
+// @contract:mutating
+func (c *FactoryContract) ApplyCodeUpdate(input ProposalInput) error {
+	proposal, err := c.openProposal(input.Id)
+	if err != nil {
+		return err
+	}
+	if proposal.ApprovedAt == 0 {
+		return errors.New("the proposal has not been approved")
+	}
+	if env.GetBlockTimeMs() < proposal.ApprovedAt+c.TimelockMs {
+		return errors.New("the timelock has not expired")
+	}
+
+	var version uint64
+	if proposal.Deprecation != nil {
+		if err := c.deprecate(*proposal.Deprecation); err != nil {
+			return err
+		}
+		version = proposal.Deprecation.Version
+	} else {
+		release, err := c.releaseCode(proposal.Kind, proposal.Hash, proposal.Size, proposal.Note, Template{
+			Required: proposal.Required,
+			Optional: proposal.Optional,
+			Variants: proposal.Variants,
+			Methods:  proposal.Methods,
+		})
+		if err != nil {
+			return err
+		}
+		version = release.Version
+	}
+	event := c.proposalEvent(proposal, "")
+	event.Version = version
+	env.LogString(core.NewEvent("code_update_applied", event).String())
+	c.dropProposal(proposal.Id)
+	return nil
+}
+
+// addProposal files a proposal under the next id and counts the proposer's
+// approval. Expired proposals are dropped on the way.
+func (c *FactoryContract) addProposal(proposal CodeProposal) uint64 {
+	c.dropExpiredProposals()
+
+	c.ProposalSeq++
+	proposal.Id = c.ProposalSeq
+	proposal.ProposedAt = env.GetBlockTimeMs()
+	c.Proposals = append(c.Proposals, proposal)
+
+	added := &c.Proposals[len(c.Proposals)-1]
+	c.emitProposal("code_update_proposed", added, proposal.Proposer)
+	c.approve(added, proposal.Proposer)
+	return proposal.Id
+}
+
+// dropProposal removes a resolved proposal, and any expired ones, from the
+// state.
+func (c *FactoryContract) dropProposal(id uint64) {
+	open := c.Proposals[:0]
+	for _, proposal := range c.Proposals {
+		if proposal.Id != id {
+			open = append(open, proposal)
+		}
+	}
+	c.Proposals = open
+	c.dropExpiredProposals()
+}
+
+func (c *FactoryContract) dropExpiredProposals() {
+	now := env.GetBlockTimeMs()
+	open := c.Proposals[:0]
+	for _, proposal := range c.Proposals {
+		if !proposal.expired(now) {
+			open = append(open, proposal)
+		}
+	}
+	c.Proposals = open
+}
+
+func (c *FactoryContract) approve(proposal *CodeProposal, member string) {
+	proposal.Approvals = append(proposal.Approvals, member)
+	if proposal.ApprovedAt == 0 && uint64(len(proposal.Approvals)) >= c.Threshold {
+		proposal.ApprovedAt = env.GetBlockTimeMs()
+	}
+	c.emitProposal("code_update_approved", proposal, member)
+}
+
+func (c *FactoryContract) emitProposal(event string, proposal *CodeProposal, account string) {
+	env.LogString(core.NewEvent(event, c.proposalEvent(proposal, account)).String())
+}
+
+func (c *FactoryContract) proposalEvent(proposal *CodeProposal, account string) CodeUpdateEvent {
+	event := CodeUpdateEvent{
+		ProposalId: proposal.Id,
+		Kind:       proposal.Kind,
+		Hash:       proposal.Hash,
+		Account:    account,
+		Approvals:  uint64(len(proposal.Approvals)),
+	}
+	if proposal.ApprovedAt != 0 {
+		event.ExecutableAt = proposal.ApprovedAt + c.TimelockMs
+	}
+	return event
+}
+
+// openProposal returns a proposal that can still be approved, applied or
+// cancelled. Applied and cancelled proposals are no longer known.
+func (c *FactoryContract) openProposal(id uint64) (*CodeProposal, error) {
+	for i := range c.Proposals {
+		if c.Proposals[i].Id != id {
+			continue
+		}
+		if c.Proposals[i].expired(env.GetBlockTimeMs()) {
+			return nil, errors.New("the proposal has expired")
+		}
+		return &c.Proposals[i], nil
+	}
+	return nil, errors.New("unknown proposal")
+}
+
+func (c *FactoryContract) requireCouncil() (string, error) {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return "", errors.New("failed to get caller")
+	}
+	if !contains(c.Council, caller) {
+		return "", errors.New("only council members can call this method")
+	}
+	return caller, nil
+}
+
+func requireSelf() error {
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller")
+	}
+	current, err := env.GetCurrentAccountId()
+	if err != nil {
+		return errors.New("failed to get current account")
+	}
+	if caller != current {
+		return errors.New("only the contract itself can call this method")
+	}
+	return nil
+}
+
+// SetFees sets the deploy fee and the protocol fee share of the auctions
+// deployed from now on.
+//
+// @contract:mutating
+func (c *FactoryContract) SetFees(input SetFeesInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+
+	deployFee := "0"
+	if input.DeployFee != "" {
+		fee, err := types.U128FromString(input.DeployFee)
+		if err != nil {
+			return errors.New("invalid deploy fee")
+		}
+		deployFee = fee.String()
+	}
+	if input.ProtocolFeeBps > maxProtocolFeeBps {
+		return errors.New("protocol fee can be at most " + types.IntToString(int(maxProtocolFeeBps)) + " bps")
+	}
+
+	c.Fees.DeployFee = deployFee
+	c.Fees.ProtocolFeeBps = input.ProtocolFeeBps
+	return nil
+}
+
+// CollectFee takes the protocol fee the factory's auctions pay on
+// settlement.
+//
+// @contract:payable min_deposit=0
+func (c *FactoryContract) CollectFee() error {
+	attached, err := env.GetAttachedDeposit()
+	if err != nil {
+		return errors.New("failed to get attached deposit")
+	}
+	caller, err := env.GetPredecessorAccountID()
+	if err != nil {
+		return errors.New("failed to get caller")
+	}
+
+	c.addFee(&c.Fees.ProtocolFeesCollected, attached)
+	env.LogString("Collected a protocol fee of " + attached.String() + " from " + caller)
+	return nil
+}
+
+// WithdrawFees pays out of the fee balance, or sends FT fees held on
+// input.Token.
+//
+// @contract:mutating
+func (c *FactoryContract) WithdrawFees(input WithdrawFeesInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+
+	amount, err := types.U128FromString(input.Amount)
+	if err != nil || amount.Cmp(types.Uint128{Hi: 0, Lo: 0}) <= 0 {
+		return errors.New("invalid amount")
+	}
+	if input.Receiver == "" {
+		return errors.New("invalid receiver")
+	}
+	if input.Token != "" {
+		ftArgs := map[string]string{"receiver_id": input.Receiver, "amount": amount.String()}
+		promise.CreateBatch(input.Token).
+			FunctionCall("ft_transfer", ftArgs, types.U64ToUint128(1), uint64(types.ONE_TERA_GAS*10))
+		env.LogString("Withdrawing " + amount.String() + " " + input.Token + " in fees to " + input.Receiver)
+		return nil
+	}
+	if amountOf(c.Fees.Balance).Cmp(amount) < 0 {
+		return errors.New("amount exceeds the fee balance")
+	}
+	balance, err := amountOf(c.Fees.Balance).Sub(amount)
+	if err != nil {
+		return errors.New("amount exceeds the fee balance")
+	}
+	withdrawn, err := amountOf(c.Fees.Withdrawn).Add(amount)
+	if err != nil {
+		return errors.New("withdrawn total overflow")
+	}
+
+	c.Fees.Balance = balance.String()
+	c.Fees.Withdrawn = withdrawn.String()
+
+	current, _ := env.GetCurrentAccountId()
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+
+	promise.CreateBatch(input.Receiver).
+		Transfer(amount).
+		Then(current).
+		FunctionCall("withdraw_fees_callback", WithdrawFeesCallbackInput{Receiver: input.Receiver, Amount: amount.String()}, zero, gas5T)
+
+	return nil
+}
+
+// WithdrawFeesCallback puts a withdrawal that could not be paid back into
+// the fee balance.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FactoryContract) WithdrawFeesCallback(input WithdrawFeesCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	if result.Success {
+		env.LogString("Withdrew " + input.Amount + " in fees to " + input.Receiver)
+		return true
+	}
+
+	amount := amountOf(input.Amount)
+	balance, err := amountOf(c.Fees.Balance).Add(amount)
+	if err != nil {
+		env.LogString("Fee balance overflow")
+		return false
+	}
+	withdrawn, err := amountOf(c.Fees.Withdrawn).Sub(amount)
+	if err != nil {
+		withdrawn = types.Uint128{Hi: 0, Lo: 0}
+	}
+	c.Fees.Balance = balance.String()
+	c.Fees.Withdrawn = withdrawn.String()
+	env.LogString("Failed to withdraw " + input.Amount + " to " + input.Receiver + ", restored the fee balance")
+	return false
+}
+
+// @contract:view
+func (c *FactoryContract) GetFees() FeeInfo {
+	return FeeInfo{
+		DeployFee:             amountOf(c.Fees.DeployFee).String(),
+		ProtocolFeeBps:        c.Fees.ProtocolFeeBps,
+		Balance:               amountOf(c.Fees.Balance).String(),
+		DeployFeesCollected:   amountOf(c.Fees.DeployFeesCollected).String(),
+		ProtocolFeesCollected: amountOf(c.Fees.ProtocolFeesCollected).String(),
+		Reclaimed:             amountOf(c.Fees.Reclaimed).String(),
+		Withdrawn:             amountOf(c.Fees.Withdrawn).String(),
+	}
+}
+
+// @contract:view
+func (c *FactoryContract) GetCouncil() CouncilInfo {
+	return CouncilInfo{
+		Council:    c.Council,
+		Threshold:  c.Threshold,
+		TimelockMs: c.TimelockMs,
+	}
+}
+
+// GetProposals lists the open proposals: those not yet applied, cancelled
+// or expired.
+//
+// @contract:view
+func (c *FactoryContract) GetProposals() []CodeProposal {
+	proposals := []CodeProposal{}
+	now := env.GetBlockTimeMs()
+	for _, proposal := range c.Proposals {
+		if !proposal.expired(now) {
+			proposals = append(proposals, proposal)
+		}
+	}
+	return proposals
+}
+
+// GetCodeSize returns the size of a version of a template, its latest
+// available release when Version is 0.
+//
+// @contract:view
+func (c *FactoryContract) GetCodeSize(input TemplateInput) int {
+	kind, template, err := c.template(input.Kind)
+	if err != nil {
+		return 0
+	}
+	release, err := template.pick(kind, input.Version)
+	if err != nil {
+		return 0
+	}
+	return int(release.Size)
+}
+
+// GetReleases lists every version of a template with its code hash.
+//
+// @contract:view
+func (c *FactoryContract) GetReleases(input TemplateInput) []CodeRelease {
+	_, template, err := c.template(input.Kind)
+	if err != nil {
+		return []CodeRelease{}
+	}
+	return template.Releases
+}
+
+// GetTemplates lists the templates with the release a deployment gets when
+// it doesn't pin a version.
+//
+// @contract:view
+func (c *FactoryContract) GetTemplates() []TemplateInfo {
+	if c.Templates == nil {
+		c.seedTemplates()
+	}
+
+	kinds := make([]string, 0, len(c.Templates))
+	for kind := range c.Templates {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	infos := make([]TemplateInfo, 0, len(kinds))
+	for _, kind := range kinds {
+		template := c.Templates[kind]
+		info := TemplateInfo{
+			Kind:     kind,
+			Required: template.Required,
+			Optional: template.Optional,
+			Variants: template.Variants,
+			Methods:  template.Methods,
+		}
+		if release, err := template.pick(kind, 0); err == nil {
+			info.Version = release.Version
+			info.Hash = release.Hash
+			info.CodeSize = int(release.Size)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// SetReclaimTo picks who gets the balance of deleted auctions: their
+// creator (the default), or the factory's own account. Balances reclaimed to
+// the factory are added to the fee balance.
+//
+// @contract:mutating
+func (c *FactoryContract) SetReclaimTo(input SetReclaimToInput) error {
+	if err := requireSelf(); err != nil {
+		return err
+	}
+	if input.ReclaimTo != ReclaimToCreator && input.ReclaimTo != ReclaimToFactory {
+		return errors.New("reclaim_to must be " + ReclaimToCreator + " or " + ReclaimToFactory)
+	}
+	c.ReclaimTo = input.ReclaimTo
+	return nil
+}
+
+// SweepAuctions deletes the registered auctions in [From, From+Limit) that
+// ended more than the grace period ago, reclaiming their storage deposit.
+// Each auction checks for itself that it has been settled; the ones that
+// haven't stay in place. Anyone can call it. It returns how many auctions
+// were asked to self-destruct.
+//
+// @contract:mutating
+func (c *FactoryContract) SweepAuctions(input SweepInput) uint64 {
+	auctions, _ := c.registry()
+	current, _ := env.GetCurrentAccountId()
+	now := env.GetBlockTimeMs()
+
+	limit := input.Limit
+	if limit == 0 || limit > maxSweepLimit {
+		limit = maxSweepLimit
+	}
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas5T := uint64(types.ONE_TERA_GAS * 5)
+	gas15T := uint64(types.ONE_TERA_GAS * 15)
+
+	swept := uint64(0)
+	for i := input.From; i < input.From+limit && i < auctions.Length(); i++ {
+		record, err := auctions.Get(i)
+		if err != nil {
+			break
+		}
+		if record.Reclaimed || now < record.EndTime+core.SelfDestructGraceMs {
+			continue
+		}
+
+		beneficiary := record.Creator
+		toFactory := c.ReclaimTo == ReclaimToFactory
+		if toFactory {
+			beneficiary = current
+		}
+
+		promise.CreateBatch(record.Account).
+			FunctionCall("self_destruct", core.SelfDestructArgs{Beneficiary: beneficiary}, zero, gas15T).
+			Then(current).
+			FunctionCall("sweep_callback", SweepCallbackInput{Index: i, ToFactory: toFactory}, zero, gas5T)
+		swept++
+	}
+	return swept
+}
+
+// SweepCallback marks an auction deleted by SweepAuctions as reclaimed and
+// frees its name. The balance of an auction reclaimed to the factory, as
+// reported by its self_destruct, is added to the fee balance.
+//
+// @contract:mutating
+// @contract:promise_callback
+func (c *FactoryContract) SweepCallback(input SweepCallbackInput, result promise.PromiseResult) bool {
+	caller, _ := env.GetPredecessorAccountID()
+	current, _ := env.GetCurrentAccountId()
+	if caller != current {
+		env.LogString("Only the contract itself can call this method")
+		return false
+	}
+
+	auctions, _ := c.registry()
+	record, err := auctions.Get(input.Index)
+	if err != nil {
+		env.LogString("Unknown auction " + types.IntToString(int(input.Index)))
+		return false
+	}
+	if !result.Success {
+		env.LogString("Could not delete " + record.Account)
+		return false
+	}
+
+	record.Reclaimed = true
+	if err := auctions.Set(input.Index, record); err != nil {
+		env.LogString("Failed to update " + record.Account + ": " + err.Error())
+		return false
+	}
+	if err := c.accounts().Remove(record.Account); err != nil {
+		env.LogString("Failed to free the name " + record.Account)
+	}
+	if input.ToFactory {
+		var sent string
+		if err := json.Unmarshal(result.Data, &sent); err != nil {
+			env.LogString("Invalid balance reported by " + record.Account)
+		} else if amount, err := types.U128FromString(sent); err != nil {
+			env.LogString("Invalid balance reported by " + record.Account)
+		} else {
+			c.addFee(&c.Fees.Reclaimed, amount)
+		}
+	}
+	env.LogString("Deleted " + record.Account)
+	return true
+}
+
+// @contract:view
+func (c *FactoryContract) GetAuctionCount() uint64 {
+	auctions, _ := c.registry()
+	return auctions.Length()
+}
+
+// GetAuctions lists the registry in deployment order, Limit entries from
+// index From.
+//
+// @contract:view
+func (c *FactoryContract) GetAuctions(input GetAuctionsInput) []AuctionRecord {
+	auctions, _ := c.registry()
+
+	records := []AuctionRecord{}
+	end := input.From + pageLimit(input.Limit)
+	for i := input.From; i < end && i < auctions.Length(); i++ {
+		record, err := auctions.Get(i)
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// GetAuctionsByAuctioneer lists the auctions of one auctioneer, paged like
+// GetAuctions over that auctioneer's entries.
+//
+// @contract:view
+func (c *FactoryContract) GetAuctionsByAuctioneer(input GetAuctionsByAuctioneerInput) []AuctionRecord {
+	auctions, byAuctioneer := c.registry()
+
+	records := []AuctionRecord{}
+	indexes, err := byAuctioneer.Get(input.Auctioneer)
+	if err != nil {
+		return records
+	}
+
+	end := input.From + pageLimit(input.Limit)
+	for i := input.From; i < end && i < uint64(len(indexes)); i++ {
+		record, err := auctions.Get(indexes[i])
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func pageLimit(limit uint64) uint64 {
+	if limit == 0 {
+		return defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit
+	}
+	return limit
 }
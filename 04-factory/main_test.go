@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
 
+	"github.com/emirsuyunasanov/near-auction-go/core"
+	"github.com/mr-tron/base58"
 	"github.com/vlmoon99/near-sdk-go/env"
+	"github.com/vlmoon99/near-sdk-go/promise"
 	"github.com/vlmoon99/near-sdk-go/system"
 	"github.com/vlmoon99/near-sdk-go/types"
 )
@@ -32,19 +37,55 @@ func setupTest(t *testing.T) *FactoryContract {
 	m.Promises = nil
 
 	c := &FactoryContract{}
-	c.Init()
+	c.Init(InitInput{})
 	return c
 }
 
 func TestFactory_Init(t *testing.T) {
 	c := setupTest(t)
 
-	if len(c.Code) == 0 {
-		t.Error("expected embedded WASM code after init")
+	templates := c.GetTemplates()
+	if len(templates) != 3 {
+		t.Fatalf("expected 3 templates after init, got %d", len(templates))
 	}
-	if c.GetCodeSize() != len(embeddedAuctionWasm) {
-		t.Errorf("code size: want %d, got %d", len(embeddedAuctionWasm), c.GetCodeSize())
+	for _, template := range templates {
+		if template.CodeSize == 0 || template.Version != 1 {
+			t.Errorf("unexpected template: %+v", template)
+		}
 	}
+	if c.GetCodeSize(TemplateInput{}) != len(embeddedFtWasm) {
+		t.Errorf("code size: want %d, got %d", len(embeddedFtWasm), c.GetCodeSize(TemplateInput{}))
+	}
+}
+
+func TestFactory_CodeOutsideState(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	state, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if len(state) > 4096 {
+		t.Errorf("state should not hold the templates, got %d bytes", len(state))
+	}
+
+	code := m.Storage[codePrefix+codeHash(embeddedFtWasm)]
+	if len(code) != len(embeddedFtWasm) {
+		t.Errorf("stored ft template: want %d bytes, got %d", len(embeddedFtWasm), len(code))
+	}
+}
+
+// testWasm builds a minimal module exporting init and exports as functions.
+func testWasm(exports ...string) []byte {
+	section := []byte{byte(len(exports) + 1)}
+	for _, name := range append([]string{"init"}, exports...) {
+		section = append(section, byte(len(name)))
+		section = append(section, name...)
+		section = append(section, 0x00, 0x00)
+	}
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x07, byte(len(section))}
+	return append(module, section...)
 }
 
 func TestFactory_UpdateAuctionContract_Success(t *testing.T) {
@@ -53,14 +94,21 @@ func TestFactory_UpdateAuctionContract_Success(t *testing.T) {
 	m.PredecessorAccountIdSys = "factory.testnet"
 	m.CurrentAccountIdSys = "factory.testnet"
 
-	newWasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0xFF}
+	newWasm := testWasm("ft_on_transfer", "claim", "self_destruct")
 	encoded := base64.StdEncoding.EncodeToString(newWasm)
 
 	if err := c.UpdateAuctionContract(UpdateCodeInput{Code: encoded}); err != nil {
 		t.Fatalf("update failed: %v", err)
 	}
-	if c.GetCodeSize() != len(newWasm) {
-		t.Errorf("code size: want %d, got %d", len(newWasm), c.GetCodeSize())
+	if c.GetCodeSize(TemplateInput{}) != len(newWasm) {
+		t.Errorf("code size: want %d, got %d", len(newWasm), c.GetCodeSize(TemplateInput{}))
+	}
+	releases := c.GetReleases(TemplateInput{})
+	if len(releases) != 2 || releases[1].Version != 2 || releases[1].Hash != codeHash(newWasm) {
+		t.Errorf("unexpected releases: %+v", releases)
+	}
+	if c.GetCodeSize(TemplateInput{Version: 1}) != len(embeddedFtWasm) {
+		t.Error("the previous release should stay available")
 	}
 }
 
@@ -70,7 +118,7 @@ func TestFactory_UpdateAuctionContract_Unauthorized(t *testing.T) {
 	m.PredecessorAccountIdSys = "attacker.testnet"
 	m.CurrentAccountIdSys = "factory.testnet"
 
-	encoded := base64.StdEncoding.EncodeToString([]byte("fake"))
+	encoded := base64.StdEncoding.EncodeToString(testWasm("ft_on_transfer", "claim", "self_destruct"))
 	err := c.UpdateAuctionContract(UpdateCodeInput{Code: encoded})
 	if err == nil {
 		t.Fatal("expected error for unauthorized update, got nil")
@@ -92,6 +140,45 @@ func TestFactory_UpdateAuctionContract_InvalidBase64(t *testing.T) {
 	}
 }
 
+func TestFactory_UpdateAuctionContract_InvalidWasm(t *testing.T) {
+	c := setupTest(t)
+	valid := testWasm("ft_on_transfer", "claim", "self_destruct")
+
+	cases := []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"empty", []byte{}, "code is not a WebAssembly module"},
+		{"header", []byte("fake wasm"), "code is not a WebAssembly module"},
+		{"version", append([]byte{0x00, 0x61, 0x73, 0x6d, 0x02, 0x00, 0x00, 0x00}, valid[8:]...), "unsupported WebAssembly version"},
+		{"truncated", valid[:len(valid)-3], "truncated section"},
+		{"section", append(append([]byte{}, valid...), 0x20, 0x00), "invalid section id 32"},
+		{"init", []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, "code does not export init"},
+		{"bid", testWasm("claim"), "code does not export ft_on_transfer"},
+		{"claim", testWasm("ft_on_transfer"), "code does not export claim"},
+		{"self_destruct", testWasm("ft_on_transfer", "claim"), "code does not export self_destruct"},
+		{"size", append(append([]byte{}, valid...), make([]byte, maxCodeSize)...), "code is larger than 4194304 bytes"},
+	}
+	for _, tc := range cases {
+		err := c.UpdateAuctionContract(UpdateCodeInput{Code: base64.StdEncoding.EncodeToString(tc.code)})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%s: want %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	if err := c.UpdateAuctionContract(UpdateCodeInput{Kind: KindBasic, Code: base64.StdEncoding.EncodeToString(valid)}); err == nil {
+		t.Error("expected the basic template to require bid")
+	}
+	nftWasm := base64.StdEncoding.EncodeToString(testWasm("bid", "claim", "self_destruct"))
+	if err := c.UpdateAuctionContract(UpdateCodeInput{Kind: KindNft, Code: nftWasm}); err == nil || err.Error() != "code does not export verify_token" {
+		t.Errorf("expected the nft template to require verify_token, got %v", err)
+	}
+	if len(c.GetReleases(TemplateInput{})) != 1 {
+		t.Error("rejected code should not be released")
+	}
+}
+
 func TestFactory_DeployNewAuction_InsufficientDeposit(t *testing.T) {
 	c := setupTest(t)
 	m := mockSys(t)
@@ -142,3 +229,808 @@ func TestFactory_DeployNewAuction_InvalidName(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestFactory_UpdateAuctionContract_NewTemplate(t *testing.T) {
+	c := setupTest(t)
+	newWasm := testWasm("place_bid", "claim")
+	encoded := base64.StdEncoding.EncodeToString(newWasm)
+
+	err := c.UpdateAuctionContract(UpdateCodeInput{Kind: "dutch", Code: encoded})
+	if err == nil || err.Error() != "a new template needs its required arguments" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = c.UpdateAuctionContract(UpdateCodeInput{
+		Kind:     "dutch",
+		Code:     encoded,
+		Required: []string{"end_time", "auctioneer"},
+		Optional: []string{"floor_price"},
+		Methods:  []string{"place_bid", "claim"},
+	})
+	if err != nil {
+		t.Fatalf("adding template failed: %v", err)
+	}
+	if c.GetCodeSize(TemplateInput{Kind: "dutch"}) != len(newWasm) {
+		t.Errorf("code size: want %d, got %d", len(newWasm), c.GetCodeSize(TemplateInput{Kind: "dutch"}))
+	}
+	if len(c.GetTemplates()) != 4 {
+		t.Errorf("expected 4 templates, got %d", len(c.GetTemplates()))
+	}
+}
+
+func TestFactory_DeployNewAuction_Kinds(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+
+	err := c.DeployNewAuction(DeployInput{Name: "a1", Kind: "dutch", EndTime: 9999999, Auctioneer: "user.testnet"})
+	if err == nil || err.Error() != "unknown auction kind dutch" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{Name: "a1", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet", NftContract: "nft.testnet"})
+	if err == nil || err.Error() != "argument nft_contract is not accepted by the basic template" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{Name: "a1", Kind: KindNft, EndTime: 9999999, Auctioneer: "user.testnet", NftContract: "nft.testnet"})
+	if err == nil || err.Error() != "the nft template needs nft_contract, token_id together" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{
+		Name:        "a1",
+		Kind:        KindNft,
+		EndTime:     9999999,
+		Auctioneer:  "user.testnet",
+		NftContract: "nft.testnet",
+		TokenId:     "token-1",
+		Args:        map[string]json.RawMessage{"token_id": json.RawMessage(`"token-2"`)},
+	})
+	if err == nil || err.Error() != "argument token_id is given twice" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{
+		Name:       "a1",
+		Kind:       KindBasic,
+		EndTime:    9999999,
+		Auctioneer: "user.testnet",
+	})
+	if err != nil {
+		t.Fatalf("deploying a basic auction failed: %v", err)
+	}
+}
+
+func TestFactory_DeployNewAuction_NftBundle(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+
+	bundle := map[string]json.RawMessage{
+		"bundle": json.RawMessage(`[{"nft_contract":"nft.testnet","token_id":"token-1"},{"nft_contract":"nft.testnet","token_id":"token-2"}]`),
+	}
+	oneOf := "the nft template needs exactly one of: nft_contract, token_id | bundle"
+
+	err := c.DeployNewAuction(DeployInput{Name: "lot", Kind: KindNft, EndTime: 9999999, Auctioneer: "user.testnet"})
+	if err == nil || err.Error() != oneOf {
+		t.Errorf("unexpected error without a token: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{
+		Name:        "lot",
+		Kind:        KindNft,
+		EndTime:     9999999,
+		Auctioneer:  "user.testnet",
+		NftContract: "nft.testnet",
+		TokenId:     "token-1",
+		Args:        bundle,
+	})
+	if err == nil || err.Error() != oneOf {
+		t.Errorf("unexpected error for a token and a bundle: %v", err)
+	}
+
+	err = c.DeployNewAuction(DeployInput{Name: "lot", Kind: KindNft, EndTime: 9999999, Auctioneer: "user.testnet", Args: bundle})
+	if err != nil {
+		t.Errorf("deploying a bundle lot failed: %v", err)
+	}
+}
+
+func TestFactory_DeprecateRelease(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	newWasm := testWasm("ft_on_transfer", "claim", "self_destruct", "v2")
+	err := c.UpdateAuctionContract(UpdateCodeInput{Code: base64.StdEncoding.EncodeToString(newWasm), Note: "bad release"})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if err := c.DeprecateRelease(DeprecateInput{Kind: KindFt, Version: 2, Deprecated: true}); err != nil {
+		t.Fatalf("deprecate failed: %v", err)
+	}
+
+	templates := c.GetTemplates()
+	if templates[1].Kind != KindFt || templates[1].Version != 1 || templates[1].Hash != codeHash(embeddedFtWasm) {
+		t.Errorf("expected a rollback to version 1: %+v", templates[1])
+	}
+
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+	input := DeployInput{
+		Name:          "a1",
+		Version:       2,
+		EndTime:       9999999,
+		Auctioneer:    "user.testnet",
+		NftContract:   "nft.testnet",
+		TokenId:       "token-1",
+		StartingPrice: "10000",
+	}
+	err = c.DeployNewAuction(input)
+	if err == nil || err.Error() != "version 2 of the ft template is deprecated" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	input.Version = 3
+	err = c.DeployNewAuction(input)
+	if err == nil || err.Error() != "unknown version 3 of the ft template" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	input.Version = 1
+	if err := c.DeployNewAuction(input); err != nil {
+		t.Errorf("deploying a pinned version failed: %v", err)
+	}
+
+	if err := c.DeprecateRelease(DeprecateInput{Kind: KindFt, Version: 1, Deprecated: true}); err == nil {
+		t.Error("expected only the factory to deprecate releases")
+	}
+}
+
+// recordGlobal stands in for the global contract host functions.
+func recordGlobal(t *testing.T) (published *[]byte, used *[]byte) {
+	t.Helper()
+	published, used = new([]byte), new([]byte)
+	deployGlobalContract = func(promiseIndex uint64, code []byte) { *published = code }
+	useGlobalContract = func(promiseIndex uint64, codeHash []byte) { *used = codeHash }
+	t.Cleanup(func() {
+		deployGlobalContract = promiseBatchActionDeployGlobalContract
+		useGlobalContract = promiseBatchActionUseGlobalContract
+	})
+	return published, used
+}
+
+func TestFactory_GlobalContract(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	published, used := recordGlobal(t)
+
+	if err := c.PublishRelease(TemplateInput{Kind: KindBasic}); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	if len(*published) != len(embeddedBasicWasm) {
+		t.Fatalf("published %d bytes, want %d", len(*published), len(embeddedBasicWasm))
+	}
+	if c.GetReleases(TemplateInput{Kind: KindBasic})[0].Published {
+		t.Fatal("the release should only be published once the callback succeeds")
+	}
+	if !c.PublishCallback(PublishCallbackInput{Kind: KindBasic, Version: 1}, promise.PromiseResult{Success: true}) {
+		t.Fatal("publish callback failed")
+	}
+	if err := c.PublishRelease(TemplateInput{Kind: KindBasic}); err == nil {
+		t.Error("expected an error publishing a release twice")
+	}
+
+	// Account and state storage is enough once the code is global.
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys, _ = types.U128FromString("100000000000000000000000")
+	input := DeployInput{Name: "a1", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"}
+	if err := c.DeployNewAuction(input); err != nil {
+		t.Fatalf("deploy failed: %v", err)
+	}
+	if len(*used) != 32 || codeHash(embeddedBasicWasm) != base58.Encode(*used) {
+		t.Errorf("expected the deployment to use the published code hash, got %x", *used)
+	}
+
+	input.Kind = KindNft
+	input.NftContract = "nft.testnet"
+	input.TokenId = "token-1"
+	err := c.DeployNewAuction(input)
+	if err == nil || err.Error() != "insufficient deposit to deploy auction" {
+		t.Errorf("an unpublished template should still charge for its code: %v", err)
+	}
+}
+
+func setupCouncil(t *testing.T) *FactoryContract {
+	t.Helper()
+	c := setupTest(t)
+	c.Init(InitInput{
+		Council:    []string{"alice.testnet", "bob.testnet", "carol.testnet"},
+		Threshold:  2,
+		TimelockMs: 1000,
+	})
+	return c
+}
+
+func TestFactory_Governance(t *testing.T) {
+	c := setupCouncil(t)
+	m := mockSys(t)
+
+	newWasm := testWasm("ft_on_transfer", "claim", "self_destruct", "v3")
+	encoded := base64.StdEncoding.EncodeToString(newWasm)
+	if err := c.UpdateAuctionContract(UpdateCodeInput{Code: encoded}); err == nil {
+		t.Fatal("expected direct updates to be rejected once a council is configured")
+	}
+
+	m.PredecessorAccountIdSys = "dev.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+	hash, err := c.UploadCode(UploadCodeInput{Code: encoded})
+	if err != nil || hash != codeHash(newWasm) {
+		t.Fatalf("upload failed: %q %v", hash, err)
+	}
+
+	if _, err := c.ProposeCodeUpdate(ProposeInput{Hash: hash}); err == nil {
+		t.Fatal("expected an error for a proposal outside the council")
+	}
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	defer func(timestamp uint64) { m.BlockTimestampSys = timestamp }(m.BlockTimestampSys)
+	m.BlockTimestampSys = 1_000_000_000
+	id, err := c.ProposeCodeUpdate(ProposeInput{Hash: hash, Note: "fix"})
+	if err != nil {
+		t.Fatalf("propose failed: %v", err)
+	}
+	if err := c.ApplyCodeUpdate(ProposalInput{Id: id}); err == nil || err.Error() != "the proposal has not been approved" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "bob.testnet"
+	if err := c.ApproveCodeUpdate(ProposalInput{Id: id}); err != nil {
+		t.Fatalf("approve failed: %v", err)
+	}
+	if err := c.ApproveCodeUpdate(ProposalInput{Id: id}); err == nil || err.Error() != "already approved" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.ApplyCodeUpdate(ProposalInput{Id: id}); err == nil || err.Error() != "the timelock has not expired" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pending := c.GetProposals()
+	if len(pending) != 1 || len(pending[0].Approvals) != 2 {
+		t.Fatalf("unexpected pending proposals: %+v", pending)
+	}
+
+	m.BlockTimestampSys += 1000 * 1_000_000
+	if err := c.ApplyCodeUpdate(ProposalInput{Id: id}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	releases := c.GetReleases(TemplateInput{})
+	if len(releases) != 2 || releases[1].Hash != hash || releases[1].Note != "fix" {
+		t.Errorf("unexpected releases: %+v", releases)
+	}
+	if len(c.GetProposals()) != 0 || len(c.Proposals) != 0 {
+		t.Error("an applied proposal should be dropped")
+	}
+	if err := c.ApplyCodeUpdate(ProposalInput{Id: id}); err == nil || err.Error() != "unknown proposal" {
+		t.Errorf("unexpected error applying a proposal twice: %v", err)
+	}
+}
+
+func TestFactory_Governance_Deprecation(t *testing.T) {
+	c := setupCouncil(t)
+	m := mockSys(t)
+
+	if err := c.DeprecateRelease(DeprecateInput{Kind: KindFt, Version: 1, Deprecated: true}); err == nil || err.Error() != "deprecations go through council proposals" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	defer func(timestamp uint64) { m.BlockTimestampSys = timestamp }(m.BlockTimestampSys)
+	m.BlockTimestampSys = 1_000_000_000
+	if _, err := c.ProposeDeprecation(DeprecateInput{Kind: KindFt, Version: 2, Deprecated: true}); err == nil {
+		t.Fatal("expected an error for an unknown release")
+	}
+	id, err := c.ProposeDeprecation(DeprecateInput{Kind: KindFt, Version: 1, Deprecated: true})
+	if err != nil {
+		t.Fatalf("propose failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "bob.testnet"
+	if err := c.ApproveCodeUpdate(ProposalInput{Id: id}); err != nil {
+		t.Fatalf("approve failed: %v", err)
+	}
+	m.BlockTimestampSys += 1000 * 1_000_000
+	if err := c.ApplyCodeUpdate(ProposalInput{Id: id}); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if !c.GetReleases(TemplateInput{Kind: KindFt})[0].Deprecated {
+		t.Error("the release should be deprecated")
+	}
+}
+
+func TestFactory_Governance_CancelAndExpiry(t *testing.T) {
+	c := setupCouncil(t)
+	m := mockSys(t)
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	defer func(timestamp uint64) { m.BlockTimestampSys = timestamp }(m.BlockTimestampSys)
+	m.BlockTimestampSys = 1_000_000_000
+	cancelled, err := c.ProposeDeprecation(DeprecateInput{Kind: KindBasic, Version: 1, Deprecated: true})
+	if err != nil {
+		t.Fatalf("propose failed: %v", err)
+	}
+	expiring, err := c.ProposeDeprecation(DeprecateInput{Kind: KindNft, Version: 1, Deprecated: true})
+	if err != nil {
+		t.Fatalf("propose failed: %v", err)
+	}
+
+	m.PredecessorAccountIdSys = "bob.testnet"
+	if err := c.CancelProposal(ProposalInput{Id: cancelled}); err == nil || err.Error() != "only the proposer can cancel the proposal" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.PredecessorAccountIdSys = "alice.testnet"
+	if err := c.CancelProposal(ProposalInput{Id: cancelled}); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	m.PredecessorAccountIdSys = "bob.testnet"
+	if err := c.ApproveCodeUpdate(ProposalInput{Id: cancelled}); err == nil || err.Error() != "unknown proposal" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Proposals) != 1 {
+		t.Errorf("a cancelled proposal should be dropped, got %d proposals", len(c.Proposals))
+	}
+
+	m.BlockTimestampSys += proposalTtlMs * 1_000_000
+	if err := c.ApproveCodeUpdate(ProposalInput{Id: expiring}); err == nil || err.Error() != "the proposal has expired" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proposals := c.GetProposals(); len(proposals) != 0 {
+		t.Errorf("cancelled and expired proposals should not be listed: %+v", proposals)
+	}
+
+	m.PredecessorAccountIdSys = "alice.testnet"
+	id, err := c.ProposeDeprecation(DeprecateInput{Kind: KindNft, Version: 1, Deprecated: true})
+	if err != nil {
+		t.Fatalf("propose failed: %v", err)
+	}
+	if id != expiring+1 {
+		t.Errorf("proposal ids should not be reused: want %d, got %d", expiring+1, id)
+	}
+	if len(c.Proposals) != 1 || c.Proposals[0].Id != id {
+		t.Errorf("the expired proposal should be dropped: %+v", c.Proposals)
+	}
+}
+
+func TestFactory_Init_InvalidCouncil(t *testing.T) {
+	c := setupTest(t)
+	if err := c.setCouncil(InitInput{Council: []string{"alice.testnet"}, Threshold: 2}); err == nil {
+		t.Error("expected an error for a threshold above the council size")
+	}
+	if err := c.setCouncil(InitInput{Council: []string{"alice.testnet", "alice.testnet"}, Threshold: 1}); err == nil {
+		t.Error("expected an error for a duplicate council member")
+	}
+}
+
+func TestFactory_DeployNewAuction_NameValidation(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+
+	cases := map[string]string{
+		"":           "subaccount name is empty",
+		"my.sub":     "subaccount name can only hold lowercase letters, digits, '-' and '_'",
+		"MyAuction":  "subaccount name can only hold lowercase letters, digits, '-' and '_'",
+		"my auction": "subaccount name can only hold lowercase letters, digits, '-' and '_'",
+		"-auction":   "subaccount name can't start, end or repeat a separator",
+		"auction_":   "subaccount name can't start, end or repeat a separator",
+		"my--sale":   "subaccount name can't start, end or repeat a separator",
+	}
+	for name, want := range cases {
+		m.PredecessorAccountIdSys = "user.testnet"
+		err := c.DeployNewAuction(DeployInput{Name: name, Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"})
+		if err == nil || err.Error() != want {
+			t.Errorf("name %q: want %q, got %v", name, want, err)
+		}
+	}
+
+	deployed(t, c, "taken", "alice.testnet")
+	m.PredecessorAccountIdSys = "user.testnet"
+	err := c.DeployNewAuction(DeployInput{Name: "taken", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"})
+	if err == nil || err.Error() != "auction taken.factory.testnet already exists" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.DeployNewAuction(DeployInput{Name: "my_sale-2", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"}); err != nil {
+		t.Errorf("valid name rejected: %v", err)
+	}
+}
+
+func TestFactory_GetDeployCost(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	cost, err := c.GetDeployCost(TemplateInput{Kind: KindBasic})
+	if err != nil {
+		t.Fatalf("get_deploy_cost failed: %v", err)
+	}
+	want, _ := types.U64ToUint128(nearPerStorageByte).SafeMul64(accountStorageBytes + uint64(len(embeddedBasicWasm)))
+	if cost != want.String() {
+		t.Errorf("deploy cost: want %s, got %s", want.String(), cost)
+	}
+	if _, err := c.GetDeployCost(TemplateInput{Kind: KindBasic, Version: 2}); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+
+	input := DeployInput{Name: "a1", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"}
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys, _ = want.Sub(types.U64ToUint128(1))
+	if err := c.DeployNewAuction(input); err == nil {
+		t.Error("expected one yocto below the deploy cost to be rejected")
+	}
+	m.AttachedDepositSys = want
+	if err := c.DeployNewAuction(input); err != nil {
+		t.Errorf("the exact deploy cost was rejected: %v", err)
+	}
+}
+
+func TestFactory_Fees(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	if err := c.SetFees(SetFeesInput{ProtocolFeeBps: maxProtocolFeeBps + 1}); err == nil {
+		t.Error("expected an error for a protocol fee above the cap")
+	}
+	if err := c.SetFees(SetFeesInput{DeployFee: "1000", ProtocolFeeBps: 250}); err != nil {
+		t.Fatalf("set fees failed: %v", err)
+	}
+	for _, input := range []DeployInput{
+		{Name: "fee", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"},
+		{Name: "fee", Kind: KindNft, EndTime: 9999999, Auctioneer: "user.testnet", NftContract: "nft.testnet", TokenId: "token-1"},
+		{Name: "fee", Kind: KindFt, EndTime: 9999999, Auctioneer: "user.testnet", NftContract: "nft.testnet", TokenId: "token-1", StartingPrice: "10000"},
+	} {
+		d, err := c.prepare(input)
+		if err != nil {
+			t.Fatalf("prepare %s: %v", input.Kind, err)
+		}
+		if _, ok := d.initArgs["protocol_fee"]; !ok {
+			t.Errorf("the %s template should be charged the protocol fee", input.Kind)
+		}
+	}
+
+	storage, _ := types.U64ToUint128(nearPerStorageByte).SafeMul64(accountStorageBytes + uint64(len(embeddedBasicWasm)))
+	want, _ := storage.Add(types.U64ToUint128(1000))
+	cost, err := c.GetDeployCost(TemplateInput{Kind: KindBasic})
+	if err != nil || cost != want.String() {
+		t.Fatalf("deploy cost: want %s, got %s (%v)", want.String(), cost, err)
+	}
+
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys = storage
+	input := DeployInput{Name: "a1", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"}
+	if err := c.DeployNewAuction(input); err == nil {
+		t.Error("expected the deploy fee to be required")
+	}
+	input.Args = map[string]json.RawMessage{"protocol_fee": json.RawMessage(`{"account":"me.testnet","bps":0}`)}
+	m.AttachedDepositSys = want
+	err = c.DeployNewAuction(input)
+	if err == nil || err.Error() != "argument protocol_fee is set by the factory" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	input.Args = map[string]json.RawMessage{"defer_verification": json.RawMessage(`true`)}
+	err = c.DeployNewAuction(input)
+	if err == nil || err.Error() != "argument defer_verification is set by the factory" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "factory.testnet"
+	callback := DeployCallbackInput{Account: "a1.factory.testnet", User: "user.testnet", Attached: want.String(), Surplus: "0", Fee: "1000", Auctioneer: "user.testnet"}
+	if !c.DeployNewAuctionCallback(callback, promise.PromiseResult{Success: true}) {
+		t.Fatal("deploy callback failed")
+	}
+
+	m.PredecessorAccountIdSys = "a1.factory.testnet"
+	m.AttachedDepositSys = types.U64ToUint128(500)
+	if err := c.CollectFee(); err != nil {
+		t.Fatalf("collect fee failed: %v", err)
+	}
+
+	fees := c.GetFees()
+	if fees.Balance != "1500" || fees.DeployFeesCollected != "1000" || fees.ProtocolFeesCollected != "500" {
+		t.Errorf("unexpected fees: %+v", fees)
+	}
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	if err := c.WithdrawFees(WithdrawFeesInput{Receiver: "ops.testnet", Amount: "2000"}); err == nil {
+		t.Error("expected an error withdrawing more than the balance")
+	}
+	if err := c.WithdrawFees(WithdrawFeesInput{Receiver: "ops.testnet", Amount: "1200"}); err != nil {
+		t.Fatalf("withdraw failed: %v", err)
+	}
+	if fees := c.GetFees(); fees.Balance != "300" || fees.Withdrawn != "1200" {
+		t.Errorf("unexpected fees after withdrawal: %+v", fees)
+	}
+
+	c.WithdrawFeesCallback(WithdrawFeesCallbackInput{Receiver: "ops.testnet", Amount: "1200"}, promise.PromiseResult{Success: false})
+	if fees := c.GetFees(); fees.Balance != "1500" || fees.Withdrawn != "0" {
+		t.Errorf("a failed withdrawal should restore the balance: %+v", fees)
+	}
+	if err := c.WithdrawFees(WithdrawFeesInput{Receiver: "ops.testnet", Amount: "5000", Token: "ft.testnet"}); err != nil {
+		t.Fatalf("withdrawing FT fees failed: %v", err)
+	}
+	if fees := c.GetFees(); fees.Balance != "1500" {
+		t.Errorf("FT fees should not touch the NEAR balance: %+v", fees)
+	}
+
+	m.PredecessorAccountIdSys = "user.testnet"
+	if err := c.WithdrawFees(WithdrawFeesInput{Receiver: "user.testnet", Amount: "1"}); err == nil {
+		t.Error("expected only the factory to withdraw fees")
+	}
+}
+
+func TestFactory_SweepAuctions(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+	deployed(t, c, "a1", "alice.testnet")
+	deployed(t, c, "a2", "alice.testnet")
+
+	defer func(timestamp uint64) { m.BlockTimestampSys = timestamp }(m.BlockTimestampSys)
+	m.BlockTimestampSys = 9999999 * 1_000_000
+	m.PredecessorAccountIdSys = "anyone.testnet"
+	if swept := c.SweepAuctions(SweepInput{}); swept != 0 {
+		t.Fatalf("swept %d auctions inside the grace period", swept)
+	}
+
+	m.BlockTimestampSys = (9999999 + core.SelfDestructGraceMs) * 1_000_000
+	if swept := c.SweepAuctions(SweepInput{Limit: 1}); swept != 1 {
+		t.Fatalf("swept %d auctions, want 1", swept)
+	}
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	if c.SweepCallback(SweepCallbackInput{Index: 1}, promise.PromiseResult{Success: false}) {
+		t.Error("a failed self_destruct should report failure")
+	}
+	if !c.SweepCallback(SweepCallbackInput{Index: 0}, promise.PromiseResult{Success: true}) {
+		t.Fatal("sweep callback failed")
+	}
+
+	page := c.GetAuctions(GetAuctionsInput{})
+	if !page[0].Reclaimed || page[1].Reclaimed {
+		t.Errorf("unexpected reclaimed flags: %+v", page)
+	}
+	if swept := c.SweepAuctions(SweepInput{}); swept != 1 {
+		t.Errorf("a reclaimed auction should not be swept again, swept %d", swept)
+	}
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	if err := c.SetReclaimTo(SetReclaimToInput{ReclaimTo: "treasury"}); err == nil {
+		t.Error("expected an error for an unknown beneficiary")
+	}
+	if err := c.SetReclaimTo(SetReclaimToInput{ReclaimTo: ReclaimToFactory}); err != nil {
+		t.Errorf("set reclaim_to failed: %v", err)
+	}
+
+	// Balances reclaimed to the factory are added to the fee balance.
+	before := amountOf(c.GetFees().Balance)
+	if !c.SweepCallback(SweepCallbackInput{Index: 1, ToFactory: true}, promise.PromiseResult{Success: true, Data: []byte(`"2500"`)}) {
+		t.Fatal("sweep callback failed")
+	}
+	fees := c.GetFees()
+	if want, _ := before.Add(types.Uint128{Hi: 0, Lo: 2_500}); fees.Balance != want.String() || fees.Reclaimed != "2500" {
+		t.Errorf("unexpected fees after reclaiming to the factory: %+v", fees)
+	}
+
+	// The name of a deleted auction can be deployed again.
+	m.PredecessorAccountIdSys = "user.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+	if err := c.DeployNewAuction(DeployInput{Name: "a1", Kind: KindBasic, EndTime: 9999999, Auctioneer: "user.testnet"}); err != nil {
+		t.Errorf("redeploying a reclaimed name failed: %v", err)
+	}
+}
+
+func deployed(t *testing.T, c *FactoryContract, name, auctioneer string) {
+	t.Helper()
+	mockSys(t).PredecessorAccountIdSys = "factory.testnet"
+	input := DeployCallbackInput{
+		Account:     name + ".factory.testnet",
+		User:        "user.testnet",
+		Attached:    "1",
+		Auctioneer:  auctioneer,
+		NftContract: "nft.testnet",
+		FtContract:  "ft.testnet",
+		TokenId:     "token-1",
+		EndTime:     9999999,
+		CodeVersion: 1,
+		CodeHash:    codeHash(embeddedFtWasm),
+	}
+	if !c.DeployNewAuctionCallback(input, promise.PromiseResult{Success: true}) {
+		t.Fatalf("registering %s failed", name)
+	}
+}
+
+func TestFactory_Registry(t *testing.T) {
+	c := setupTest(t)
+	deployed(t, c, "a1", "alice.testnet")
+	deployed(t, c, "b1", "bob.testnet")
+	deployed(t, c, "a2", "alice.testnet")
+
+	if c.GetAuctionCount() != 3 {
+		t.Fatalf("auction count: want 3, got %d", c.GetAuctionCount())
+	}
+
+	page := c.GetAuctions(GetAuctionsInput{From: 1, Limit: 5})
+	if len(page) != 2 || page[0].Account != "b1.factory.testnet" || page[1].Account != "a2.factory.testnet" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	if page[0].Creator != "user.testnet" || page[0].CodeVersion != 1 || page[0].CodeHash != codeHash(embeddedFtWasm) {
+		t.Errorf("unexpected record: %+v", page[0])
+	}
+
+	alice := c.GetAuctionsByAuctioneer(GetAuctionsByAuctioneerInput{Auctioneer: "alice.testnet"})
+	if len(alice) != 2 || alice[0].Account != "a1.factory.testnet" || alice[1].Account != "a2.factory.testnet" {
+		t.Errorf("unexpected auctions of alice: %+v", alice)
+	}
+	if len(c.GetAuctionsByAuctioneer(GetAuctionsByAuctioneerInput{Auctioneer: "carol.testnet"})) != 0 {
+		t.Error("expected no auctions for carol")
+	}
+}
+
+func TestFactory_Registry_FailedDeploy(t *testing.T) {
+	c := setupTest(t)
+	mockSys(t).PredecessorAccountIdSys = "factory.testnet"
+
+	input := DeployCallbackInput{Account: "a1.factory.testnet", User: "user.testnet", Attached: "1", Auctioneer: "alice.testnet"}
+	if c.DeployNewAuctionCallback(input, promise.PromiseResult{Success: false}) {
+		t.Error("a failed deployment should report failure")
+	}
+	if c.GetAuctionCount() != 0 {
+		t.Errorf("a failed deployment should not be registered, got %d", c.GetAuctionCount())
+	}
+
+	mockSys(t).PredecessorAccountIdSys = "mallory.testnet"
+	if c.DeployNewAuctionCallback(input, promise.PromiseResult{Success: true}) {
+		t.Error("expected false for a callback from another account")
+	}
+	if c.GetAuctionCount() != 0 {
+		t.Errorf("an outside call should not be registered, got %d", c.GetAuctionCount())
+	}
+}
+
+func TestFactory_NftListing(t *testing.T) {
+	c := setupTest(t)
+	m := mockSys(t)
+
+	m.PredecessorAccountIdSys = "seller.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 1_000_000, Lo: 0}
+	if err := c.Deposit(DepositInput{}); err == nil || err.Error() != "missing nft_contract" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.Deposit(DepositInput{NftContract: "nft.testnet"}); err != nil {
+		t.Fatalf("deposit failed: %v", err)
+	}
+	deposit := c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"})
+	if deposit != m.AttachedDepositSys.String() {
+		t.Fatalf("unexpected deposit: %s", deposit)
+	}
+
+	m.PredecessorAccountIdSys = "nft.testnet"
+	m.AttachedDepositSys = types.Uint128{Hi: 0, Lo: 0}
+	listing := func(msg string) NftOnTransferInput {
+		return NftOnTransferInput{
+			SenderId:        "seller.testnet",
+			PreviousOwnerId: "seller.testnet",
+			TokenId:         "token-1",
+			Msg:             msg,
+		}
+	}
+
+	rejected := []struct {
+		msg  string
+		want string
+	}{
+		{"not json", "invalid listing msg"},
+		{`{"name":"A1","end_time":9999999}`, "subaccount name can only hold lowercase letters, digits, '-' and '_'"},
+		{`{"name":"a1"}`, "missing argument end_time for the nft template"},
+		{`{"name":"a1","end_time":9999999,"args":{"floor":"1"}}`, "argument floor is not accepted by the nft template"},
+		{`{"name":"a1","end_time":9999999,"max_cost":"1"}`, "listing costs "},
+	}
+	for _, tc := range rejected {
+		err := c.NftOnTransfer(listing(tc.msg))
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("%s: want %q, got %v", tc.msg, tc.want, err)
+		}
+	}
+	if c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}) != deposit {
+		t.Fatal("a rejected listing should not charge the deposit")
+	}
+
+	input := listing(`{"name":"a1","end_time":9999999}`)
+	input.SenderId = "broke.testnet"
+	if err := c.NftOnTransfer(input); err == nil {
+		t.Error("expected an error for a sender without a deposit")
+	}
+
+	// A contract that is not the one the deposit was made for cannot spend
+	// it by naming the seller as the sender.
+	m.PredecessorAccountIdSys = "fake-nft.testnet"
+	if err := c.NftOnTransfer(listing(`{"name":"a1","end_time":9999999}`)); err == nil || !strings.HasPrefix(err.Error(), "listing costs ") {
+		t.Errorf("expected a listing from another contract to be rejected, got %v", err)
+	}
+	if c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}) != deposit {
+		t.Fatal("a listing from another contract should not charge the deposit")
+	}
+	m.PredecessorAccountIdSys = "nft.testnet"
+
+	// The factory still holds the token when the auction is initialized.
+	d, err := c.prepareListing(DeployInput{Name: "a1", Kind: KindNft, EndTime: 9999999, Auctioneer: "seller.testnet", NftContract: "nft.testnet", TokenId: "token-1"})
+	if err != nil || string(d.initArgs["defer_verification"]) != "true" {
+		t.Errorf("a listing should defer the token verification: %v", err)
+	}
+
+	if err := c.NftOnTransfer(listing(`{"name":"a1","end_time":9999999}`)); err != nil {
+		t.Fatalf("listing failed: %v", err)
+	}
+	cost := amountOf(costOf(t, c, KindNft))
+	left, _ := amountOf(deposit).Sub(cost)
+	if c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}) != left.String() {
+		t.Errorf("expected the deposit to be charged %s, got %s", cost.String(), c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}))
+	}
+
+	m.PredecessorAccountIdSys = "factory.testnet"
+	callback := DeployCallbackInput{
+		Account:     "a1.factory.testnet",
+		User:        "seller.testnet",
+		Attached:    cost.String(),
+		Surplus:     "0",
+		Fee:         "0",
+		Kind:        KindNft,
+		Auctioneer:  "seller.testnet",
+		NftContract: "nft.testnet",
+		TokenId:     "token-1",
+		EndTime:     9999999,
+		CodeVersion: 1,
+	}
+	if !c.NftListingCallback(callback, promise.PromiseResult{Success: false}) {
+		t.Error("a failed listing should have the token returned")
+	}
+	if c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}) != deposit {
+		t.Error("a failed listing should credit the deposit back")
+	}
+	if c.GetAuctionCount() != 0 {
+		t.Error("a failed listing should not be registered")
+	}
+
+	if c.NftListingCallback(callback, promise.PromiseResult{Success: true}) {
+		t.Error("a deployed listing should keep the token")
+	}
+	auctions := c.GetAuctionsByAuctioneer(GetAuctionsByAuctioneerInput{Auctioneer: "seller.testnet"})
+	if len(auctions) != 1 || auctions[0].Account != "a1.factory.testnet" || auctions[0].Kind != KindNft || auctions[0].TokenId != "token-1" {
+		t.Errorf("unexpected auctions: %+v", auctions)
+	}
+
+	m.PredecessorAccountIdSys = "mallory.testnet"
+	if c.NftListingCallback(callback, promise.PromiseResult{Success: false}) {
+		t.Error("expected false for a callback from another account")
+	}
+
+	m.PredecessorAccountIdSys = "seller.testnet"
+	if err := c.WithdrawDeposit(WithdrawDepositInput{NftContract: "nft.testnet", Amount: "1"}); err != nil {
+		t.Fatalf("withdraw failed: %v", err)
+	}
+	if err := c.WithdrawDeposit(WithdrawDepositInput{NftContract: "nft.testnet"}); err != nil {
+		t.Fatalf("withdraw failed: %v", err)
+	}
+	if c.GetDeposit(GetDepositInput{Account: "seller.testnet", NftContract: "nft.testnet"}) != "0" {
+		t.Error("expected the whole deposit to be withdrawn")
+	}
+	if err := c.WithdrawDeposit(WithdrawDepositInput{NftContract: "nft.testnet"}); err == nil || err.Error() != "nothing to withdraw" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func costOf(t *testing.T, c *FactoryContract, kind string) string {
+	t.Helper()
+	cost, err := c.GetDeployCost(TemplateInput{Kind: kind})
+	if err != nil {
+		t.Fatalf("deploy cost: %v", err)
+	}
+	return cost
+}
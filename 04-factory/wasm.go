@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/vlmoon99/near-sdk-go/types"
+)
+
+// maxCodeSize is NEAR's limit on the size of a contract.
+const maxCodeSize = 4 * 1024 * 1024
+
+// validateWasm checks that code is a WebAssembly module the factory can
+// deploy: the \0asm header with version 1, at most maxCodeSize bytes, well
+// formed sections and exported functions for init and each of methods.
+func validateWasm(code []byte, methods []string) error {
+	if len(code) > maxCodeSize {
+		return errors.New("code is larger than " + types.IntToString(maxCodeSize) + " bytes")
+	}
+	if len(code) < 8 || string(code[:4]) != "\x00asm" {
+		return errors.New("code is not a WebAssembly module")
+	}
+	if code[4] != 1 || code[5] != 0 || code[6] != 0 || code[7] != 0 {
+		return errors.New("unsupported WebAssembly version")
+	}
+
+	exports, err := wasmExports(code[8:])
+	if err != nil {
+		return err
+	}
+	for _, method := range append([]string{"init"}, methods...) {
+		if !contains(exports, method) {
+			return errors.New("code does not export " + method)
+		}
+	}
+	return nil
+}
+
+// wasmExports walks the sections of a module body and returns the names of
+// its exported functions.
+func wasmExports(body []byte) ([]string, error) {
+	exports := []string{}
+	for len(body) > 0 {
+		id := body[0]
+		size, n, err := readLeb(body[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if id > 12 {
+			return nil, errors.New("invalid section id " + types.IntToString(int(id)))
+		}
+		if uint64(len(body)-start) < size {
+			return nil, errors.New("truncated section")
+		}
+		section := body[start : start+int(size)]
+		body = body[start+int(size):]
+
+		// Section 7 is the export section.
+		if id != 7 {
+			continue
+		}
+		count, n, err := readLeb(section)
+		if err != nil {
+			return nil, err
+		}
+		section = section[n:]
+		for i := uint64(0); i < count; i++ {
+			nameLen, n, err := readLeb(section)
+			if err != nil {
+				return nil, err
+			}
+			section = section[n:]
+			if uint64(len(section)) < nameLen+1 {
+				return nil, errors.New("truncated export section")
+			}
+			name := string(section[:nameLen])
+			kind := section[nameLen]
+			section = section[nameLen+1:]
+			if _, n, err = readLeb(section); err != nil {
+				return nil, err
+			}
+			section = section[n:]
+
+			// Kind 0 exports a function.
+			if kind == 0 {
+				exports = append(exports, name)
+			}
+		}
+	}
+	return exports, nil
+}
+
+// readLeb decodes an unsigned 32-bit LEB128 value and returns it with the
+// number of bytes it took.
+func readLeb(data []byte) (uint64, int, error) {
+	value := uint64(0)
+	for i := 0; i < 5 && i < len(data); i++ {
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return value, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("invalid LEB128 value")
+}
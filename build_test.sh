@@ -1,7 +1,7 @@
 #!/usr/bin/env bash
 # build_test.sh — full build + unit test + integration test for all contracts
 # Usage: ./build_test.sh
-# Requirements: near-go CLI v0.1.1, Rust + cargo, NEAR sandbox
+# Requirements: near-go CLI v0.1.1, Rust + cargo, cargo-near, NEAR sandbox
 
 set -euo pipefail
 
@@ -28,6 +28,7 @@ header() {
 echo -e "\n${BOLD}Checking dependencies...${NC}"
 command -v near-go >/dev/null 2>&1 || { echo -e "${RED}near-go not found${NC}"; exit 1; }
 command -v cargo   >/dev/null 2>&1 || { echo -e "${RED}cargo not found${NC}"; exit 1; }
+cargo near --version >/dev/null 2>&1 || { echo -e "${RED}cargo-near not found${NC}"; exit 1; }
 NEAR_GO_VERSION=$(near-go version 2>&1 | grep -o 'v[0-9.]*' || echo "unknown")
 echo -e "  near-go: ${GREEN}${NEAR_GO_VERSION}${NC}"
 echo -e "  cargo:   ${GREEN}$(cargo --version)${NC}"
@@ -60,6 +61,20 @@ run_contract() {
     cd "$REPO_ROOT"
 }
 
+# ── test-contracts/nft ────────────────────────────────────────────
+# NEP-171 contract the 02 and 03 integration tests list tokens from.
+header "test-contracts/nft"
+step "Build"
+(cd "$REPO_ROOT/test-contracts/nft" && cargo near build non-reproducible-wasm)
+pass "Build OK → target/near/test_nft.wasm"
+
+# ── test-contracts/ft ─────────────────────────────────────────────
+# NEP-141 contract the 03 integration tests bid and settle with.
+header "test-contracts/ft"
+step "Build"
+(cd "$REPO_ROOT/test-contracts/ft" && cargo near build non-reproducible-wasm)
+pass "Build OK → target/near/test_ft.wasm"
+
 # ── 01-basic-auction ──────────────────────────────────────────────
 run_contract "01-basic-auction"
 
@@ -70,12 +85,17 @@ run_contract "02-nft-auction"
 run_contract "03-ft-auction"
 
 # ── 04-factory ────────────────────────────────────────────────────
-# Factory embeds auction.wasm at compile time.
-# We use the freshly built 03-ft-auction WASM as the embedded contract.
-header "04-factory  (pre-build: copy auction.wasm)"
-step "Copy 03-ft-auction/main.wasm → 04-factory/auction.wasm"
-cp "$REPO_ROOT/03-ft-auction/main.wasm" "$REPO_ROOT/04-factory/auction.wasm"
-pass "auction.wasm copied ($(wc -c < "$REPO_ROOT/04-factory/auction.wasm") bytes)"
+# Factory embeds its auction templates at compile time.
+# We use the freshly built 01/02/03 WASM as the embedded templates.
+header "04-factory  (pre-build: copy templates)"
+mkdir -p "$REPO_ROOT/04-factory/templates"
+for template in "01-basic-auction:basic" "02-nft-auction:nft" "03-ft-auction:ft"; do
+    src="${template%%:*}"
+    kind="${template##*:}"
+    step "Copy $src/main.wasm → 04-factory/templates/$kind.wasm"
+    cp "$REPO_ROOT/$src/main.wasm" "$REPO_ROOT/04-factory/templates/$kind.wasm"
+    pass "$kind.wasm copied ($(wc -c < "$REPO_ROOT/04-factory/templates/$kind.wasm") bytes)"
+done
 
 run_contract "04-factory"
 
@@ -1,7 +1,346 @@
 package core
 
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/vlmoon99/near-sdk-go/env"
+	"github.com/vlmoon99/near-sdk-go/promise"
+	"github.com/vlmoon99/near-sdk-go/types"
+)
+
+// EventStandard and EventVersion identify the NEP-297 events the auction
+// contracts log.
+const (
+	EventStandard = "near-auction"
+	EventVersion  = "1.0.0"
+)
+
+// FeeBpsDenominator is the basis point scale of ProtocolFee.Bps.
+const FeeBpsDenominator = 10_000
+
+// ProtocolFee is the share of the auctioneer's proceeds an auction pays to
+// the factory that deployed it. The fee is sent with a call to collect_fee
+// on Account.
+type ProtocolFee struct {
+	Account string `json:"account"`
+	Bps     uint64 `json:"bps"`
+}
+
+// SplitFee splits bps basis points off amount and returns the fee and the
+// rest.
+func SplitFee(amount types.Uint128, bps uint64) (types.Uint128, types.Uint128, error) {
+	fee, err := amount.SafeMul64(bps)
+	if err != nil {
+		return fee, amount, err
+	}
+	fee, err = fee.Div(types.U64ToUint128(FeeBpsDenominator))
+	if err != nil {
+		return fee, amount, err
+	}
+	rest, err := amount.Sub(fee)
+	return fee, rest, err
+}
+
+// CollectFee sends the protocol fee on a native NEAR amount to the fee
+// account with collect_fee and returns the rest. Without a fee, or when it
+// can't be computed, the whole amount is returned.
+func CollectFee(protocolFee *ProtocolFee, amount types.Uint128) (types.Uint128, error) {
+	if protocolFee == nil || protocolFee.Bps == 0 {
+		return amount, nil
+	}
+	fee, rest, err := SplitFee(amount, protocolFee.Bps)
+	if err != nil {
+		return amount, err
+	}
+
+	if fee.Cmp(types.Uint128{Hi: 0, Lo: 0}) > 0 {
+		gas5T := uint64(types.ONE_TERA_GAS * 5)
+		promise.CreateBatch(protocolFee.Account).FunctionCall("collect_fee", struct{}{}, fee, gas5T)
+	}
+	return rest, nil
+}
+
+// ValidAccountId reports whether id is a valid NEAR account ID: 2 to 64
+// characters in dot-separated labels of lowercase letters and digits, with
+// single '-' or '_' between them.
+func ValidAccountId(id string) bool {
+	if len(id) < 2 || len(id) > 64 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9':
+		case ch == '-' || ch == '_' || ch == '.':
+			if i == 0 || i == len(id)-1 || !isAlphanumeric(id[i-1]) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9'
+}
+
+// SelfDestructGraceMs is how long after its end time a settled auction has
+// to stay up before the factory that deployed it can delete it.
+const SelfDestructGraceMs = uint64(30 * 24 * 60 * 60 * 1000)
+
+// SelfDestructArgs are the arguments of an auction's self_destruct call.
+type SelfDestructArgs struct {
+	Beneficiary string `json:"beneficiary"`
+}
+
+// DeleteSelf deletes the current account in favour of beneficiary and returns
+// the balance it hands over, read before the deletion. The reward for the
+// gas of the calling method lands on the account afterwards, so the
+// beneficiary receives at least the returned amount.
+func DeleteSelf(beneficiary string) (string, error) {
+	balance, err := env.GetAccountBalance()
+	if err != nil {
+		return "", errors.New("failed to get the account balance")
+	}
+	current, _ := env.GetCurrentAccountId()
+	env.LogString("Deleting the auction, sending its balance to " + beneficiary)
+	promise.CreateBatch(current).DeleteAccount(beneficiary)
+	return balance.String(), nil
+}
+
 // Bid represents a single bid placed in an auction.
 type Bid struct {
 	Bidder string `json:"bidder"`
 	Amount string `json:"amount"`
 }
+
+// NftTransferArgs are the arguments of a NEP-171 nft_transfer call.
+// ApprovalId is set when the auction moves a token it was approved for
+// instead of one it holds in escrow.
+type NftTransferArgs struct {
+	ReceiverId string  `json:"receiver_id"`
+	TokenId    string  `json:"token_id"`
+	ApprovalId *uint64 `json:"approval_id,omitempty"`
+}
+
+// NftTransferPayoutArgs are the arguments of a NEP-199 nft_transfer_payout call.
+type NftTransferPayoutArgs struct {
+	ReceiverId   string  `json:"receiver_id"`
+	TokenId      string  `json:"token_id"`
+	ApprovalId   *uint64 `json:"approval_id,omitempty"`
+	Balance      string  `json:"balance"`
+	MaxLenPayout uint32  `json:"max_len_payout"`
+}
+
+// NftPayoutArgs are the arguments of a NEP-199 nft_payout view call.
+type NftPayoutArgs struct {
+	TokenId      string `json:"token_id"`
+	Balance      string `json:"balance"`
+	MaxLenPayout uint32 `json:"max_len_payout"`
+}
+
+// NftTokenArgs are the arguments of a NEP-171 nft_token view call.
+type NftTokenArgs struct {
+	TokenId string `json:"token_id"`
+}
+
+// Token is the NEP-171 token returned by nft_token, with the NEP-177
+// metadata fields the auctions care about.
+type Token struct {
+	TokenId  string         `json:"token_id"`
+	OwnerId  string         `json:"owner_id"`
+	Metadata *TokenMetadata `json:"metadata"`
+}
+
+// TokenMetadata is the part of the NEP-177 token metadata cached by the
+// auctions for display.
+type TokenMetadata struct {
+	Title     string `json:"title"`
+	Media     string `json:"media"`
+	Reference string `json:"reference"`
+}
+
+// ParseToken decodes the result of nft_token and checks that it is tokenId
+// and that it is owned by one of owners.
+func ParseToken(data []byte, tokenId string, owners ...string) (Token, error) {
+	var token Token
+	if len(data) == 0 || string(data) == "null" {
+		return token, errors.New("token does not exist")
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return token, errors.New("invalid nft_token result")
+	}
+	if token.TokenId != tokenId {
+		return token, errors.New("nft_token returned a different token")
+	}
+	for _, owner := range owners {
+		if token.OwnerId == owner {
+			if token.Metadata == nil {
+				token.Metadata = &TokenMetadata{}
+			}
+			return token, nil
+		}
+	}
+	return token, errors.New("token is owned by " + token.OwnerId)
+}
+
+// VerifyTokenCallbackInput are the arguments of the verify_token_callback
+// scheduled by VerifyToken.
+type VerifyTokenCallbackInput struct {
+	NftContract string `json:"nft_contract"`
+	TokenId     string `json:"token_id"`
+}
+
+// VerifyToken looks tokenId up with nft_token on nftContract and has the
+// result checked by the verify_token_callback of the current account, see
+// VerifiedToken.
+func VerifyToken(nftContract string, tokenId string) {
+	currentAccount, _ := env.GetCurrentAccountId()
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	gas10T := uint64(types.ONE_TERA_GAS * 10)
+
+	callbackArgs := VerifyTokenCallbackInput{
+		NftContract: nftContract,
+		TokenId:     tokenId,
+	}
+
+	promise.CreateBatch(nftContract).
+		FunctionCall("nft_token", NftTokenArgs{TokenId: tokenId}, zero, gas10T).
+		Then(currentAccount).
+		FunctionCall("verify_token_callback", callbackArgs, zero, gas10T)
+}
+
+// VerifiedToken checks the nft_token result received by a
+// verify_token_callback: the call has to have succeeded and returned tokenId,
+// owned by one of owners.
+func VerifiedToken(result promise.PromiseResult, tokenId string, owners ...string) (Token, error) {
+	if !result.Success {
+		return Token{}, errors.New("nft_token call failed")
+	}
+	return ParseToken(result.Data, tokenId, owners...)
+}
+
+// Payout is the NEP-199 payout returned by nft_transfer_payout, mapping each
+// receiver to its share of the balance.
+type Payout struct {
+	Payout map[string]string `json:"payout"`
+}
+
+// MaxLenPayout caps how many royalty receivers settlement will pay out.
+const MaxLenPayout = uint32(10)
+
+// ProbeLenPayout is the max_len_payout of the nft_payout probe. It is high
+// enough for a NEP-199 contract to answer with its whole payout, so that a
+// token with too many royalty receivers is not mistaken for a contract
+// without payout support.
+const ProbeLenPayout = uint32(1000)
+
+// PayoutShare is one transfer settlement makes out of the winning bid.
+type PayoutShare struct {
+	Receiver string
+	Amount   types.Uint128
+}
+
+// ParsePayout decodes a NEP-199 payout and checks that settlement can honor
+// it, that is that it has at most MaxLenPayout receivers.
+func ParsePayout(data []byte) (Payout, error) {
+	var payout Payout
+	if err := json.Unmarshal(data, &payout); err != nil {
+		return payout, errors.New("invalid payout")
+	}
+	if uint32(len(payout.Payout)) > MaxLenPayout {
+		return payout, errors.New("too many payout receivers")
+	}
+	return payout, nil
+}
+
+// PayoutShares turns the NEP-199 payout returned for balance into the
+// transfers settlement has to make, sorted by receiver. The share the NFT
+// contract assigns to the token owner (the auction itself when the token is
+// escrowed) goes to the auctioneer, together with anything the royalties
+// leave over; the auctioneer's share comes last.
+func PayoutShares(data []byte, balance types.Uint128, auctioneer string, auction string) ([]PayoutShare, error) {
+	payout, err := ParsePayout(data)
+	if err != nil {
+		return nil, err
+	}
+
+	receivers := make([]string, 0, len(payout.Payout))
+	for receiver := range payout.Payout {
+		receivers = append(receivers, receiver)
+	}
+	sort.Strings(receivers)
+
+	zero := types.Uint128{Hi: 0, Lo: 0}
+	royalties := zero
+	shares := make([]PayoutShare, 0, len(receivers)+1)
+	for _, receiver := range receivers {
+		amount, err := types.U128FromString(payout.Payout[receiver])
+		if err != nil {
+			return nil, errors.New("invalid payout amount for " + receiver)
+		}
+		if receiver == auction || receiver == auctioneer || amount.Cmp(zero) == 0 {
+			continue
+		}
+
+		royalties, err = royalties.Add(amount)
+		if err != nil {
+			return nil, errors.New("payout overflow")
+		}
+		shares = append(shares, PayoutShare{Receiver: receiver, Amount: amount})
+	}
+
+	if royalties.Cmp(balance) > 0 {
+		return nil, errors.New("payout exceeds balance")
+	}
+
+	rest, err := balance.Sub(royalties)
+	if err != nil {
+		return nil, errors.New("payout exceeds balance")
+	}
+	if rest.Cmp(zero) > 0 {
+		shares = append(shares, PayoutShare{Receiver: auctioneer, Amount: rest})
+	}
+
+	return shares, nil
+}
+
+// Event is a NEP-297 event. It is emitted by logging its String form.
+type Event struct {
+	Standard string      `json:"standard"`
+	Version  string      `json:"version"`
+	Event    string      `json:"event"`
+	Data     interface{} `json:"data"`
+}
+
+// NewEvent builds an auction event carrying a single data entry.
+func NewEvent(event string, data interface{}) Event {
+	return Event{
+		Standard: EventStandard,
+		Version:  EventVersion,
+		Event:    event,
+		Data:     []interface{}{data},
+	}
+}
+
+// String formats the event as an EVENT_JSON log line.
+func (e Event) String() string {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return ""
+	}
+	return "EVENT_JSON:" + string(data)
+}
+
+// NoSaleEvent is the data of the no_sale event, logged when an auction ends
+// without bids and its token goes back to the seller.
+type NoSaleEvent struct {
+	NftContract string `json:"nft_contract"`
+	TokenId     string `json:"token_id"`
+	ReturnedTo  string `json:"returned_to"`
+}
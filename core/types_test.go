@@ -0,0 +1,133 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/vlmoon99/near-sdk-go/promise"
+	"github.com/vlmoon99/near-sdk-go/types"
+)
+
+func TestSplitFee(t *testing.T) {
+	fee, rest, err := SplitFee(types.U64ToUint128(10_000), 250)
+	if err != nil {
+		t.Fatalf("split failed: %v", err)
+	}
+	if fee.String() != "250" || rest.String() != "9750" {
+		t.Errorf("split: want 250/9750, got %s/%s", fee.String(), rest.String())
+	}
+}
+
+func TestCollectFee_NoFee(t *testing.T) {
+	amount := types.U64ToUint128(10_000)
+	rest, err := CollectFee(nil, amount)
+	if err != nil || rest.Cmp(amount) != 0 {
+		t.Errorf("without a fee the whole amount should be left: %s %v", rest.String(), err)
+	}
+}
+
+func TestPayoutShares_Royalties(t *testing.T) {
+	payout := []byte(`{"payout":{"creator.testnet":"100","auction.testnet":"850","gallery.testnet":"50"}}`)
+	shares, err := PayoutShares(payout, types.U64ToUint128(1000), "auctioneer.testnet", "auction.testnet")
+	if err != nil {
+		t.Fatalf("PayoutShares failed: %v", err)
+	}
+
+	want := []PayoutShare{
+		{Receiver: "creator.testnet", Amount: types.U64ToUint128(100)},
+		{Receiver: "gallery.testnet", Amount: types.U64ToUint128(50)},
+		{Receiver: "auctioneer.testnet", Amount: types.U64ToUint128(850)},
+	}
+	if len(shares) != len(want) {
+		t.Fatalf("shares: want %d, got %d", len(want), len(shares))
+	}
+	for i, share := range shares {
+		if share.Receiver != want[i].Receiver || share.Amount.Cmp(want[i].Amount) != 0 {
+			t.Errorf("share %d: want %s %s, got %s %s", i, want[i].Receiver, want[i].Amount.String(), share.Receiver, share.Amount.String())
+		}
+	}
+}
+
+func TestPayoutShares_ExceedsBalance(t *testing.T) {
+	payout := []byte(`{"payout":{"creator.testnet":"600","gallery.testnet":"600"}}`)
+	if _, err := PayoutShares(payout, types.U64ToUint128(1000), "auctioneer.testnet", "auction.testnet"); err == nil {
+		t.Fatal("expected error for payout above balance, got nil")
+	}
+}
+
+func TestParsePayout(t *testing.T) {
+	if _, err := ParsePayout([]byte(`not json`)); err == nil || err.Error() != "invalid payout" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	payout := `{"payout":{`
+	for i := 0; i < int(MaxLenPayout); i++ {
+		if i > 0 {
+			payout += ","
+		}
+		payout += `"creator` + strconv.Itoa(i) + `.testnet":"1"`
+	}
+	if _, err := ParsePayout([]byte(payout + `}}`)); err != nil {
+		t.Errorf("a payout with %d receivers should be accepted: %v", MaxLenPayout, err)
+	}
+
+	payout += `,"creator.testnet":"1"}}`
+	if _, err := ParsePayout([]byte(payout)); err == nil || err.Error() != "too many payout receivers" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := PayoutShares([]byte(payout), types.U64ToUint128(1000), "auctioneer.testnet", "auction.testnet"); err == nil {
+		t.Error("expected error for too many payout receivers, got nil")
+	}
+}
+
+func TestVerifiedToken(t *testing.T) {
+	owners := []string{"auctioneer.testnet", "auction.testnet"}
+	cases := []struct {
+		name   string
+		result promise.PromiseResult
+		want   string
+	}{
+		{"call failed", promise.PromiseResult{Success: false}, "nft_token call failed"},
+		{"missing", promise.PromiseResult{Success: true, Data: []byte(`null`)}, "token does not exist"},
+		{"invalid", promise.PromiseResult{Success: true, Data: []byte(`[]`)}, "invalid nft_token result"},
+		{"other token", promise.PromiseResult{Success: true, Data: []byte(`{"token_id":"token-2","owner_id":"auctioneer.testnet"}`)}, "nft_token returned a different token"},
+		{"wrong owner", promise.PromiseResult{Success: true, Data: []byte(`{"token_id":"token-1","owner_id":"mallory.testnet"}`)}, "token is owned by mallory.testnet"},
+	}
+	for _, tc := range cases {
+		if _, err := VerifiedToken(tc.result, "token-1", owners...); err == nil || err.Error() != tc.want {
+			t.Errorf("%s: want %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	for _, owner := range owners {
+		result := promise.PromiseResult{Success: true, Data: []byte(`{"token_id":"token-1","owner_id":"` + owner + `","metadata":{"title":"Sunset","media":"sunset.png","reference":null}}`)}
+		token, err := VerifiedToken(result, "token-1", owners...)
+		if err != nil {
+			t.Fatalf("a token owned by %s should be verified: %v", owner, err)
+		}
+		if token.Metadata == nil || token.Metadata.Title != "Sunset" || token.Metadata.Media != "sunset.png" {
+			t.Errorf("unexpected metadata: %+v", token.Metadata)
+		}
+	}
+
+	result := promise.PromiseResult{Success: true, Data: []byte(`{"token_id":"token-1","owner_id":"auction.testnet"}`)}
+	if token, err := VerifiedToken(result, "token-1", owners...); err != nil || token.Metadata == nil {
+		t.Errorf("a token without metadata should get empty metadata: %+v %v", token.Metadata, err)
+	}
+}
+
+func TestValidAccountId(t *testing.T) {
+	valid := []string{"ab", "alice.testnet", "a-b_c.near", "0x1234", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	for _, id := range valid {
+		if !ValidAccountId(id) {
+			t.Errorf("%q should be valid", id)
+		}
+	}
+
+	invalid := []string{"", "a", "Alice.testnet", "alice..testnet", ".alice", "alice.", "a-.b", "a_-b", "alice testnet", "alice@testnet", "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08a"}
+	for _, id := range invalid {
+		if ValidAccountId(id) {
+			t.Errorf("%q should be invalid", id)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+//! Minimal NEP-171 NFT contract (with NEP-177 metadata, NEP-178 approvals and
+//! NEP-199 payouts) the integration tests list tokens from. Royalties are set
+//! per token at mint; the owner gets whatever they leave of the balance.
+
+use std::collections::HashMap;
+
+use near_contract_standards::non_fungible_token::approval::NonFungibleTokenApproval;
+use near_contract_standards::non_fungible_token::core::{
+    NonFungibleTokenCore, NonFungibleTokenResolver,
+};
+use near_contract_standards::non_fungible_token::metadata::{
+    NFTContractMetadata, NonFungibleTokenMetadataProvider, TokenMetadata, NFT_METADATA_SPEC,
+};
+use near_contract_standards::non_fungible_token::{NonFungibleToken, Token, TokenId};
+use near_sdk::collections::{LazyOption, LookupMap};
+use near_sdk::json_types::U128;
+use near_sdk::{env, near, require, AccountId, BorshStorageKey, PanicOnDefault, Promise, PromiseOrValue};
+
+/// Royalties are given in basis points of the sale balance.
+const ROYALTY_DENOMINATOR: u128 = 10_000;
+
+/// NEP-199 payout: how much of a sale each account receives.
+#[near(serializers = [json])]
+pub struct Payout {
+    pub payout: HashMap<AccountId, U128>,
+}
+
+#[derive(PanicOnDefault)]
+#[near(contract_state)]
+pub struct Contract {
+    tokens: NonFungibleToken,
+    metadata: LazyOption<NFTContractMetadata>,
+    royalties: LookupMap<TokenId, HashMap<AccountId, u32>>,
+}
+
+#[derive(BorshStorageKey)]
+#[near]
+enum StorageKey {
+    NonFungibleToken,
+    Metadata,
+    TokenMetadata,
+    Enumeration,
+    Approval,
+    Royalties,
+}
+
+#[near]
+impl Contract {
+    #[init]
+    pub fn new_default_meta(owner_id: AccountId) -> Self {
+        let metadata = NFTContractMetadata {
+            spec: NFT_METADATA_SPEC.to_string(),
+            name: "Auction test NFT".to_string(),
+            symbol: "TEST".to_string(),
+            icon: None,
+            base_uri: None,
+            reference: None,
+            reference_hash: None,
+        };
+        Self {
+            tokens: NonFungibleToken::new(
+                StorageKey::NonFungibleToken,
+                owner_id,
+                Some(StorageKey::TokenMetadata),
+                Some(StorageKey::Enumeration),
+                Some(StorageKey::Approval),
+            ),
+            metadata: LazyOption::new(StorageKey::Metadata, Some(&metadata)),
+            royalties: LookupMap::new(StorageKey::Royalties),
+        }
+    }
+
+    /// Mints a token; the attached deposit pays for its storage. `royalties`
+    /// maps receivers to their share of every sale in basis points.
+    #[payable]
+    pub fn nft_mint(
+        &mut self,
+        token_id: TokenId,
+        token_owner_id: AccountId,
+        token_metadata: TokenMetadata,
+        royalties: Option<HashMap<AccountId, u32>>,
+    ) -> Token {
+        require!(
+            env::predecessor_account_id() == self.tokens.owner_id,
+            "only the contract owner can mint"
+        );
+        if let Some(royalties) = royalties {
+            let total: u32 = royalties.values().sum();
+            require!(u128::from(total) <= ROYALTY_DENOMINATOR, "royalties exceed 100%");
+            self.royalties.insert(&token_id, &royalties);
+        }
+        self.tokens
+            .internal_mint(token_id, token_owner_id, Some(token_metadata))
+    }
+
+    /// NEP-199: splits `balance` between the royalty receivers of the token
+    /// and its owner.
+    pub fn nft_payout(&self, token_id: TokenId, balance: U128, max_len_payout: Option<u32>) -> Payout {
+        let owner_id = self
+            .tokens
+            .owner_by_id
+            .get(&token_id)
+            .unwrap_or_else(|| env::panic_str("token not found"));
+        let royalties = self.royalties.get(&token_id).unwrap_or_default();
+        if let Some(max_len_payout) = max_len_payout {
+            require!(
+                royalties.len() < max_len_payout as usize,
+                "the payout has more receivers than max_len_payout"
+            );
+        }
+
+        let mut payout = HashMap::new();
+        let mut rest = balance.0;
+        for (account_id, bps) in royalties {
+            if account_id == owner_id {
+                continue;
+            }
+            let amount = balance.0 * u128::from(bps) / ROYALTY_DENOMINATOR;
+            rest -= amount;
+            payout.insert(account_id, U128(amount));
+        }
+        payout.insert(owner_id, U128(rest));
+        Payout { payout }
+    }
+
+    /// NEP-199: transfers the token and returns the payout of `balance`
+    /// computed for its previous owner.
+    #[payable]
+    pub fn nft_transfer_payout(
+        &mut self,
+        receiver_id: AccountId,
+        token_id: TokenId,
+        approval_id: Option<u64>,
+        memo: Option<String>,
+        balance: U128,
+        max_len_payout: Option<u32>,
+    ) -> Payout {
+        let payout = self.nft_payout(token_id.clone(), balance, max_len_payout);
+        self.tokens
+            .nft_transfer(receiver_id, token_id, approval_id, memo);
+        payout
+    }
+}
+
+#[near]
+impl NonFungibleTokenCore for Contract {
+    #[payable]
+    fn nft_transfer(
+        &mut self,
+        receiver_id: AccountId,
+        token_id: TokenId,
+        approval_id: Option<u64>,
+        memo: Option<String>,
+    ) {
+        self.tokens
+            .nft_transfer(receiver_id, token_id, approval_id, memo);
+    }
+
+    #[payable]
+    fn nft_transfer_call(
+        &mut self,
+        receiver_id: AccountId,
+        token_id: TokenId,
+        approval_id: Option<u64>,
+        memo: Option<String>,
+        msg: String,
+    ) -> PromiseOrValue<bool> {
+        self.tokens
+            .nft_transfer_call(receiver_id, token_id, approval_id, memo, msg)
+    }
+
+    fn nft_token(&self, token_id: TokenId) -> Option<Token> {
+        self.tokens.nft_token(token_id)
+    }
+}
+
+#[near]
+impl NonFungibleTokenResolver for Contract {
+    #[private]
+    fn nft_resolve_transfer(
+        &mut self,
+        previous_owner_id: AccountId,
+        receiver_id: AccountId,
+        token_id: TokenId,
+        approved_account_ids: Option<HashMap<AccountId, u64>>,
+    ) -> bool {
+        self.tokens.nft_resolve_transfer(
+            previous_owner_id,
+            receiver_id,
+            token_id,
+            approved_account_ids,
+        )
+    }
+}
+
+#[near]
+impl NonFungibleTokenApproval for Contract {
+    #[payable]
+    fn nft_approve(
+        &mut self,
+        token_id: TokenId,
+        account_id: AccountId,
+        msg: Option<String>,
+    ) -> Option<Promise> {
+        self.tokens.nft_approve(token_id, account_id, msg)
+    }
+
+    #[payable]
+    fn nft_revoke(&mut self, token_id: TokenId, account_id: AccountId) {
+        self.tokens.nft_revoke(token_id, account_id);
+    }
+
+    #[payable]
+    fn nft_revoke_all(&mut self, token_id: TokenId) {
+        self.tokens.nft_revoke_all(token_id);
+    }
+
+    fn nft_is_approved(
+        &self,
+        token_id: TokenId,
+        approved_account_id: AccountId,
+        approval_id: Option<u64>,
+    ) -> bool {
+        self.tokens
+            .nft_is_approved(token_id, approved_account_id, approval_id)
+    }
+}
+
+#[near]
+impl NonFungibleTokenMetadataProvider for Contract {
+    fn nft_metadata(&self) -> NFTContractMetadata {
+        self.metadata.get().unwrap()
+    }
+}